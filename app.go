@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-tools/go-xamarin/builder"
+	"github.com/bitrise-tools/go-xamarin/constants"
+)
+
+// projectAppPath returns the .app generated for the project, or an empty string if there is no such output.
+func projectAppPath(projectOutput builder.ProjectOutputModel) string {
+	appPth := ""
+	for _, output := range projectOutput.Outputs {
+		if output.OutputType == constants.OutputTypeAPP {
+			appPth = output.Pth
+		}
+	}
+	return appPth
+}
+
+func plistValue(plistPth, key string) (string, error) {
+	cmd := command.New("/usr/libexec/PlistBuddy", "-c", "Print :"+key, plistPth)
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("Failed to read %s from (%s), output: %s, error: %s", key, plistPth, out, err)
+	}
+	return out, nil
+}
+
+// appBundleID reads the bundle identifier from the app's Info.plist.
+func appBundleID(appPth string) (string, error) {
+	return plistValue(filepath.Join(appPth, "Info.plist"), "CFBundleIdentifier")
+}
+
+// appArchitectures returns the architectures of the app's executable.
+func appArchitectures(appPth string) ([]string, error) {
+	executable, err := plistValue(filepath.Join(appPth, "Info.plist"), "CFBundleExecutable")
+	if err != nil {
+		return nil, err
+	}
+
+	executablePth := filepath.Join(appPth, executable)
+	out, err := command.New("lipo", "-archs", executablePth).RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read architectures of (%s), output: %s, error: %s", executablePth, out, err)
+	}
+	return strings.Fields(out), nil
+}
+
+// hostArchitecture returns the native architecture of the machine (arm64 or x86_64),
+// even if the step runs under Rosetta.
+func hostArchitecture() string {
+	if out, err := command.New("sysctl", "-n", "hw.optional.arm64").RunAndReturnTrimmedCombinedOutput(); err == nil && out == "1" {
+		return "arm64"
+	}
+	return "x86_64"
+}
+
+// checkAppArchitecture fails if the app can not run on the simulators of the host.
+func checkAppArchitecture(appPth, hostArch string) error {
+	archs, err := appArchitectures(appPth)
+	if err != nil {
+		return err
+	}
+
+	for _, arch := range archs {
+		if arch == hostArch {
+			return nil
+		}
+	}
+
+	remediation := "set the MtouchArch of the iOS project's simulator configuration to x86_64, or the RuntimeIdentifier to iossimulator-x64"
+	if hostArch == "arm64" {
+		remediation = "set the MtouchArch of the iOS project's simulator configuration to arm64 (or x86_64,arm64), or the RuntimeIdentifier to iossimulator-arm64"
+	}
+
+	return fmt.Errorf("App (%s) is built for %s, but the simulators of this machine run %s apps, %s", filepath.Base(appPth), strings.Join(archs, ", "), hostArch, remediation)
+}
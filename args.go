@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// splitArgs splits the command line arguments the way a POSIX shell does:
+// arguments are separated by whitespace, single quotes preserve every character,
+// double quotes preserve every character except the backslash escaped double quote, backslash, dollar sign and backtick,
+// and outside of quotes the backslash escapes the next character.
+func splitArgs(line string) ([]string, error) {
+	args := []string{}
+
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				arg.WriteRune(c)
+			}
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else if c == '\\' && i+1 < len(runes) && strings.ContainsRune("\"\\$`", runes[i+1]) {
+				i++
+				arg.WriteRune(runes[i])
+			} else {
+				arg.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == '\\':
+			if i+1 == len(runes) {
+				return nil, fmt.Errorf("trailing backslash in: %s", line)
+			}
+			i++
+			if runes[i] != '\n' {
+				arg.WriteRune(runes[i])
+				inArg = true
+			}
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in: %s", quote, line)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return args, nil
+}
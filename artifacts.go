@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+var (
+	screenshotExts  = []string{".png", ".jpg", ".jpeg"}
+	crashReportExts = []string{".crash", ".ips"}
+)
+
+func hasExt(pth string, exts ...string) bool {
+	ext := strings.ToLower(filepath.Ext(pth))
+	for _, e := range exts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+func isScreenshot(pth string) bool {
+	return hasExt(pth, screenshotExts...)
+}
+
+func isCrashReport(pth string) bool {
+	return hasExt(pth, crashReportExts...)
+}
+
+// filesModifiedSince returns the files of the dir (not recursive) which were modified since the given time.
+func filesModifiedSince(dir string, since time.Time, match func(pth string) bool) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	pths := []string{}
+	for _, info := range infos {
+		if info.IsDir() || info.ModTime().Before(since) {
+			continue
+		}
+
+		pth := filepath.Join(dir, info.Name())
+		if match(pth) {
+			pths = append(pths, pth)
+		}
+	}
+	return pths, nil
+}
+
+// collectFilesModifiedSince copies the matching files created since the given time in the given dirs into the deploy dir,
+// prefixed with the name of the test run. It returns the copies by the original paths.
+func collectFilesModifiedSince(dirs []string, since time.Time, deployDir, prefix string, match func(pth string) bool) (map[string]string, error) {
+	collected := map[string]string{}
+	seen := map[string]bool{}
+	if absDeployDir, err := filepath.Abs(deployDir); err == nil {
+		seen[absDeployDir] = true
+	}
+
+	for _, dir := range dirs {
+		absDir, err := filepath.Abs(dir)
+		if err != nil || seen[absDir] {
+			continue
+		}
+		seen[absDir] = true
+
+		pths, err := filesModifiedSince(absDir, since, match)
+		if err != nil {
+			return collected, fmt.Errorf("Failed to search for files in (%s), error: %s", absDir, err)
+		}
+
+		for _, pth := range pths {
+			dst := filepath.Join(deployDir, sanitizedFileName(prefix)+"-"+filepath.Base(pth))
+			if err := command.CopyFile(pth, dst); err != nil {
+				return collected, fmt.Errorf("Failed to copy file (%s), error: %s", pth, err)
+			}
+			collected[pth] = dst
+		}
+	}
+
+	return collected, nil
+}
+
+// collectScreenshots copies the screenshots created since the given time in the given dirs into the deploy dir,
+// prefixed with the name of the test run.
+func collectScreenshots(dirs []string, since time.Time, deployDir, prefix string) (map[string]string, error) {
+	return collectFilesModifiedSince(dirs, since, deployDir, prefix, isScreenshot)
+}
+
+// collectTestAttachments copies the files attached to the test cases (TestContext.AddTestAttachment) into the deploy dir,
+// into a dedicated dir per test case: <test run>-attachments/<test case>/<file>. It returns the copies by the attached paths.
+func collectTestAttachments(results TestResultsModel, deployDir, prefix string) (map[string]string, error) {
+	collected := map[string]string{}
+	for _, testCase := range results.TestCases {
+		for _, attachment := range testCase.Attachments {
+			pth := strings.TrimSpace(attachment.FilePath)
+			if pth == "" {
+				continue
+			}
+
+			if exist, err := pathutil.IsPathExists(pth); err != nil {
+				return collected, fmt.Errorf("Failed to check if file (%s) exist, error: %s", pth, err)
+			} else if !exist {
+				log.Warnf("Attachment of test (%s) not found: %s", testCase.FullName, pth)
+				continue
+			}
+
+			dir := filepath.Join(deployDir, sanitizedFileName(prefix)+"-attachments", sanitizedFileName(testCase.FullName))
+			if err := pathutil.EnsureDirExist(dir); err != nil {
+				return collected, fmt.Errorf("Failed to create dir (%s), error: %s", dir, err)
+			}
+
+			dst := filepath.Join(dir, filepath.Base(pth))
+			if err := command.CopyFile(pth, dst); err != nil {
+				return collected, fmt.Errorf("Failed to copy file (%s), error: %s", pth, err)
+			}
+			collected[pth] = dst
+		}
+	}
+	return collected, nil
+}
+
+// rewriteArtifactReferences replaces the original paths of the files copied into the deploy dir
+// with their paths relative to the deploy dir, so the references in the exported reports point to the build artifacts.
+func rewriteArtifactReferences(text string, deployedFiles map[string]string, deployDir string) string {
+	pths := []string{}
+	for pth := range deployedFiles {
+		pths = append(pths, pth)
+	}
+	// longer paths first, so a path is not replaced partially by its prefix
+	sort.Slice(pths, func(i, j int) bool {
+		return len(pths[i]) > len(pths[j])
+	})
+
+	for _, pth := range pths {
+		deployedPth := deployedFiles[pth]
+		if relPth, err := filepath.Rel(deployDir, deployedPth); err == nil {
+			deployedPth = relPth
+		}
+		text = strings.Replace(text, pth, deployedPth, -1)
+	}
+	return text
+}
+
+// isTraceLog returns true for the internal trace logs of nunit3-console and its agents
+// (InternalTrace.<pid>.log, InternalTrace.<pid>.<assembly>.log).
+func isTraceLog(pth string) bool {
+	name := filepath.Base(pth)
+	return strings.HasPrefix(name, "InternalTrace.") && filepath.Ext(name) == ".log"
+}
+
+// collectTraceLogs copies the NUnit internal trace logs created since the given time in the given dirs into the deploy dir,
+// prefixed with the name of the test run.
+func collectTraceLogs(dirs []string, since time.Time, deployDir, prefix string) (map[string]string, error) {
+	return collectFilesModifiedSince(dirs, since, deployDir, prefix, isTraceLog)
+}
+
+// collectCrashReports copies the crash reports created during the test run
+// from the host's and the simulator's crash report directories into the deploy dir.
+func collectCrashReports(sim SimulatorModel, since time.Time, deployDir, prefix string) ([]string, error) {
+	homeDir := pathutil.UserHomeDir()
+	dirs := []string{
+		filepath.Join(homeDir, "Library", "Logs", "DiagnosticReports"),
+		filepath.Join(sim.DataDir(), "Library", "Logs", "CrashReporter"),
+	}
+
+	collected := []string{}
+	for _, dir := range dirs {
+		if exist, err := pathutil.IsDirExists(dir); err != nil {
+			return collected, err
+		} else if !exist {
+			continue
+		}
+
+		if err := filepath.Walk(dir, func(pth string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || info.ModTime().Before(since) || !isCrashReport(pth) {
+				return nil
+			}
+
+			dst := filepath.Join(deployDir, sanitizedFileName(prefix)+"-"+filepath.Base(pth))
+			if err := command.CopyFile(pth, dst); err != nil {
+				return fmt.Errorf("Failed to copy crash report (%s), error: %s", pth, err)
+			}
+			collected = append(collected, dst)
+			return nil
+		}); err != nil {
+			return collected, fmt.Errorf("Failed to collect crash reports from (%s), error: %s", dir, err)
+		}
+	}
+
+	return collected, nil
+}
+
+// zipDir compresses the dir into the given zip file, the zip contains the dir itself.
+func zipDir(dir, zipPth string) error {
+	if out, err := command.New("ditto", "-c", "-k", "--keepParent", dir, zipPth).RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("Failed to zip (%s), output: %s, error: %s", dir, out, err)
+	}
+	return nil
+}
+
+// collectSimulatorDiagnostics collects the CoreSimulator diagnostics with `simctl diagnose`
+// and zips them into the deploy dir.
+func collectSimulatorDiagnostics(sim SimulatorModel, deployDir, prefix string) (string, error) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("simulator-diagnostics")
+	if err != nil {
+		return "", fmt.Errorf("Failed to create temp dir, error: %s", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			log.Warnf("Failed to remove temp dir (%s), error: %s", tmpDir, err)
+		}
+	}()
+
+	name := sanitizedFileName(prefix) + "-simulator-diagnostics"
+	diagnosticsDir := filepath.Join(tmpDir, name)
+	if _, err := simctl("diagnose", "-b", "--no-archive", "--udid="+sim.Info.ID, "--output="+diagnosticsDir); err != nil {
+		return "", err
+	}
+
+	zipPth := filepath.Join(deployDir, name+".zip")
+	if err := zipDir(diagnosticsDir, zipPth); err != nil {
+		return "", err
+	}
+	return zipPth, nil
+}
+
+// exportAppDataContainer zips the data container of the app installed on the simulator into the deploy dir.
+func exportAppDataContainer(sim SimulatorModel, bundleID, deployDir, prefix string) (string, error) {
+	containerDir, err := simctl("get_app_container", sim.Info.ID, bundleID, "data")
+	if err != nil {
+		return "", err
+	}
+
+	zipPth := filepath.Join(deployDir, sanitizedFileName(prefix)+"-app-data.zip")
+	if err := zipDir(containerDir, zipPth); err != nil {
+		return "", err
+	}
+	return zipPth, nil
+}
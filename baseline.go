@@ -0,0 +1,25 @@
+package main
+
+// compareWithBaseline splits the failed tests into the new failures and the ones already failing in the baseline result xml.
+func compareWithBaseline(baselineResultPth string, failedTests []string) ([]string, []string, error) {
+	baseline, err := parseTestResultsFile(baselineResultPth)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	failedInBaseline := map[string]bool{}
+	for _, name := range failedTestNames(baseline) {
+		failedInBaseline[name] = true
+	}
+
+	newFailures := []string{}
+	knownFailures := []string{}
+	for _, name := range failedTests {
+		if failedInBaseline[name] {
+			knownFailures = append(knownFailures, name)
+		} else {
+			newFailures = append(newFailures, name)
+		}
+	}
+	return newFailures, knownFailures, nil
+}
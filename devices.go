@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-tools/go-xcode/simulator"
+	"github.com/hashicorp/go-version"
+)
+
+// latestDeviceFamilies maps the simulator_device keywords to the device family they select the newest device of.
+var latestDeviceFamilies = map[string]string{
+	"latest-iphone": "iPhone",
+	"latest-ipad":   "iPad",
+}
+
+// DeviceTypeModel ...
+type DeviceTypeModel struct {
+	Name       string `json:"name"`
+	Identifier string `json:"identifier"`
+}
+
+// RuntimeModel ...
+type RuntimeModel struct {
+	Name         string `json:"name"`
+	Identifier   string `json:"identifier"`
+	Version      string `json:"version"`
+	IsAvailable  bool   `json:"isAvailable"`
+	Availability string `json:"availability"`
+
+	SupportedDeviceTypes []DeviceTypeModel `json:"supportedDeviceTypes"`
+}
+
+// SupportsDeviceType reports whether simulators of the device type can be created with the runtime,
+// older Xcode versions do not list the supported device types, then every device type is accepted.
+func (runtime RuntimeModel) SupportsDeviceType(identifier string) bool {
+	if len(runtime.SupportedDeviceTypes) == 0 {
+		return true
+	}
+	for _, deviceType := range runtime.SupportedDeviceTypes {
+		if deviceType.Identifier == identifier {
+			return true
+		}
+	}
+	return false
+}
+
+// Available reports whether the runtime can be used,
+// older Xcode versions describe the availability in the availability field.
+func (runtime RuntimeModel) Available() bool {
+	return runtime.IsAvailable || runtime.Availability == "(available)"
+}
+
+// listDeviceTypesAndRuntimes returns the simulator device types and runtimes known by the active Xcode.
+func listDeviceTypesAndRuntimes() ([]DeviceTypeModel, []RuntimeModel, error) {
+	var list struct {
+		DeviceTypes []DeviceTypeModel `json:"devicetypes"`
+		Runtimes    []RuntimeModel    `json:"runtimes"`
+	}
+	if err := simctlList(&list); err != nil {
+		return nil, nil, err
+	}
+
+	return list.DeviceTypes, list.Runtimes, nil
+}
+
+// DeviceModel ...
+type DeviceModel struct {
+	Name         string `json:"name"`
+	UDID         string `json:"udid"`
+	State        string `json:"state"`
+	IsAvailable  bool   `json:"isAvailable"`
+	Availability string `json:"availability"`
+}
+
+// Available ...
+func (device DeviceModel) Available() bool {
+	return device.IsAvailable || device.Availability == "(available)"
+}
+
+// getOsVersionSimulatorInfosMap lists the available simulators grouped by their os version (like `iOS 12.1`).
+// Newer Xcode versions group the devices by the runtime identifier, older ones by the runtime name.
+func getOsVersionSimulatorInfosMap() (simulator.OsVersionSimulatorInfosMap, error) {
+	var list struct {
+		Runtimes []RuntimeModel           `json:"runtimes"`
+		Devices  map[string][]DeviceModel `json:"devices"`
+	}
+	if err := simctlList(&list); err != nil {
+		return nil, err
+	}
+
+	runtimeNames := map[string]string{}
+	for _, runtime := range list.Runtimes {
+		runtimeNames[runtime.Identifier] = runtime.Name
+	}
+
+	osVersionSimulatorInfosMap := simulator.OsVersionSimulatorInfosMap{}
+	for runtime, devices := range list.Devices {
+		osVersion := runtime
+		if name, ok := runtimeNames[runtime]; ok {
+			osVersion = name
+		}
+
+		for _, device := range devices {
+			if !device.Available() {
+				continue
+			}
+
+			osVersionSimulatorInfosMap[osVersion] = append(osVersionSimulatorInfosMap[osVersion], simulator.InfoModel{
+				Name:   device.Name,
+				ID:     device.UDID,
+				Status: device.State,
+			})
+		}
+	}
+
+	return osVersionSimulatorInfosMap, nil
+}
+
+// findRuntime returns the available iOS runtime for the given os version (like `iOS 12.1` or `latest`).
+func findRuntime(runtimes []RuntimeModel, osVersion string) (RuntimeModel, error) {
+	var latest *RuntimeModel
+	var latestVersion *version.Version
+
+	for i, runtime := range runtimes {
+		if !runtime.Available() || !strings.HasPrefix(runtime.Name, "iOS") {
+			continue
+		}
+
+		if osVersion != "latest" {
+			if runtime.Name == osVersion {
+				return runtime, nil
+			}
+			continue
+		}
+
+		runtimeVersion, err := version.NewVersion(runtime.Version)
+		if err != nil {
+			continue
+		}
+		if latestVersion == nil || runtimeVersion.GreaterThan(latestVersion) {
+			latest = &runtimes[i]
+			latestVersion = runtimeVersion
+		}
+	}
+
+	if latest == nil {
+		return RuntimeModel{}, fmt.Errorf("No available runtime found for os version: %s", osVersion)
+	}
+	return *latest, nil
+}
+
+// createSimulator creates a simulator with the given device type and os version,
+// the latest-iphone and latest-ipad keywords select the newest device type of the family supported by the runtime.
+func createSimulator(osVersion, deviceName string) (simulator.InfoModel, error) {
+	deviceTypes, runtimes, err := listDeviceTypesAndRuntimes()
+	if err != nil {
+		return simulator.InfoModel{}, err
+	}
+
+	runtime, err := findRuntime(runtimes, osVersion)
+	if err != nil {
+		return simulator.InfoModel{}, err
+	}
+
+	deviceTypeID := ""
+	if family, ok := latestDeviceFamilies[deviceName]; ok {
+		for _, deviceType := range deviceTypes {
+			if strings.HasPrefix(deviceType.Name, family) && runtime.SupportsDeviceType(deviceType.Identifier) {
+				deviceTypeID = deviceType.Identifier
+				deviceName = deviceType.Name
+			}
+		}
+	} else {
+		for _, deviceType := range deviceTypes {
+			if deviceType.Name == deviceName {
+				deviceTypeID = deviceType.Identifier
+				break
+			}
+		}
+	}
+	if deviceTypeID == "" {
+		return simulator.InfoModel{}, fmt.Errorf("No device type found with name: %s", deviceName)
+	}
+	if !runtime.SupportsDeviceType(deviceTypeID) {
+		return simulator.InfoModel{}, fmt.Errorf("Device type (%s) is not supported by runtime: %s", deviceName, runtime.Name)
+	}
+
+	udid, err := simctl("create", deviceName, deviceTypeID, runtime.Identifier)
+	if err != nil {
+		return simulator.InfoModel{}, err
+	}
+
+	return simulator.InfoModel{
+		Name:   deviceName,
+		ID:     udid,
+		Status: "Shutdown",
+	}, nil
+}
+
+// latestSimulatorInfo returns the simulator of the newest device type of the given family (iPhone or iPad).
+// simctl lists the device types from the oldest to the newest.
+func latestSimulatorInfo(infos []simulator.InfoModel, family string) (simulator.InfoModel, error) {
+	deviceTypes, _, err := listDeviceTypesAndRuntimes()
+	if err != nil {
+		return simulator.InfoModel{}, err
+	}
+
+	for i := len(deviceTypes) - 1; i >= 0; i-- {
+		deviceType := deviceTypes[i]
+		if !strings.HasPrefix(deviceType.Name, family) {
+			continue
+		}
+
+		for _, info := range infos {
+			if info.Name == deviceType.Name {
+				return info, nil
+			}
+		}
+	}
+
+	return simulator.InfoModel{}, fmt.Errorf("No %s simulator found", family)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// freeDiskSpace returns the space available for the user on the volume of the given path, in bytes.
+func freeDiskSpace(pth string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(pth, &stat); err != nil {
+		return 0, fmt.Errorf("Failed to get disk usage of (%s), error: %s", pth, err)
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
+
+// volumeID returns the id of the device the path is stored on.
+func volumeID(pth string) (uint64, error) {
+	info, err := os.Stat(pth)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to get file info of (%s), error: %s", pth, err)
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("Failed to get device of (%s)", pth)
+	}
+	return uint64(stat.Dev), nil
+}
+
+// checkFreeDiskSpace checks whether the volumes of the given paths have at least minFreeGB free space,
+// it returns the low disk space messages.
+func checkFreeDiskSpace(pths []string, minFreeGB int) ([]string, error) {
+	minFree := uint64(minFreeGB) << 30
+	checked := map[uint64]bool{}
+	messages := []string{}
+
+	for _, pth := range pths {
+		if pth == "" {
+			continue
+		}
+		if exist, err := pathutil.IsPathExists(pth); err != nil {
+			return nil, fmt.Errorf("Failed to check if path (%s) exist, error: %s", pth, err)
+		} else if !exist {
+			log.Printf("%s: does not exist, skipping...", pth)
+			continue
+		}
+
+		volume, err := volumeID(pth)
+		if err != nil {
+			return nil, err
+		}
+		if checked[volume] {
+			continue
+		}
+		checked[volume] = true
+
+		free, err := freeDiskSpace(pth)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("%s: %.1f GB free", pth, float64(free)/(1<<30))
+
+		if free >= minFree {
+			continue
+		}
+
+		messages = append(messages, fmt.Sprintf("Only %.1f GB free disk space on the volume of %s, at least %d GB is required", float64(free)/(1<<30), pth, minFreeGB))
+	}
+
+	return messages, nil
+}
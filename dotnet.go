@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-tools/go-xamarin/analyzers/project"
+	"github.com/bitrise-tools/go-xamarin/analyzers/solution"
+	"github.com/bitrise-tools/go-xamarin/builder"
+	"github.com/bitrise-tools/go-xamarin/constants"
+	"github.com/bitrise-tools/go-xamarin/tools"
+	"github.com/bitrise-tools/go-xamarin/tools/buildtools"
+	"github.com/bitrise-tools/go-xamarin/utility"
+)
+
+const (
+	dotnetBuildTool = "dotnet-msbuild"
+
+	sdkStyleProjectPattern = `(?i)<Project\s+[^>]*Sdk="`
+)
+
+// dotnetMsbuildModel builds an SDK-style project with `dotnet msbuild`.
+type dotnetMsbuildModel struct {
+	solutionPth   string
+	projectPth    string
+	configuration string
+	platform      string
+
+	customOptions []string
+}
+
+// SetCustomOptions ...
+func (dotnet *dotnetMsbuildModel) SetCustomOptions(options ...string) {
+	dotnet.customOptions = options
+}
+
+func (dotnet *dotnetMsbuildModel) commandSlice() []string {
+	cmdSlice := []string{"dotnet", "msbuild", dotnet.projectPth, "/restore", "/target:Build"}
+
+	cmdSlice = append(cmdSlice, fmt.Sprintf("/p:SolutionDir=%s%c", filepath.Dir(dotnet.solutionPth), filepath.Separator))
+
+	if dotnet.configuration != "" {
+		cmdSlice = append(cmdSlice, fmt.Sprintf("/p:Configuration=%s", dotnet.configuration))
+	}
+	if dotnet.platform != "" {
+		cmdSlice = append(cmdSlice, fmt.Sprintf("/p:Platform=%s", dotnet.platform))
+	}
+
+	return append(cmdSlice, dotnet.customOptions...)
+}
+
+// PrintableCommand ...
+func (dotnet *dotnetMsbuildModel) PrintableCommand() string {
+	return command.PrintableCommandArgs(false, dotnet.commandSlice())
+}
+
+// Run ...
+func (dotnet *dotnetMsbuildModel) Run() error {
+	cmd, err := command.NewFromSlice(dotnet.commandSlice())
+	if err != nil {
+		return err
+	}
+
+	cmd.SetStdout(os.Stdout)
+	cmd.SetStderr(os.Stderr)
+
+	return cmd.Run()
+}
+
+func isSDKStyleProject(pth string) (bool, error) {
+	content, err := fileutil.ReadStringFromFile(pth)
+	if err != nil {
+		return false, fmt.Errorf("Failed to read project (%s), error: %s", pth, err)
+	}
+
+	return regexp.MustCompile(sdkStyleProjectPattern).MatchString(content), nil
+}
+
+// sdkStyleTestProjectNames returns the names of the solution's SDK-style Xamarin.UITest projects.
+func sdkStyleTestProjectNames(solutionPth string) (map[string]bool, error) {
+	sln, err := solution.New(solutionPth, true)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to analyze solution (%s), error: %s", solutionPth, err)
+	}
+
+	names := map[string]bool{}
+	for _, proj := range sln.ProjectMap {
+		if proj.TestFramework != constants.TestFrameworkXamarinUITest {
+			continue
+		}
+
+		sdkStyle, err := isSDKStyleProject(proj.Pth)
+		if err != nil {
+			return nil, err
+		}
+		if sdkStyle {
+			names[proj.Name] = true
+		}
+	}
+	return names, nil
+}
+
+func splitProjectConfig(proj project.Model, configuration, platform string) (string, string, error) {
+	solutionConfig := utility.ToConfig(configuration, platform)
+
+	projectConfig, ok := proj.ConfigMap[solutionConfig]
+	if !ok {
+		return "", "", fmt.Errorf("Project (%s) do not have config for solution config (%s)", proj.Name, solutionConfig)
+	}
+
+	configurationPlatform := utility.SplitAndStripList(projectConfig, "|")
+	projectConfiguration := configurationPlatform[0]
+	projectPlatform := ""
+	if len(configurationPlatform) > 1 && configurationPlatform[1] != "AnyCPU" && configurationPlatform[1] != "Any CPU" {
+		projectPlatform = configurationPlatform[1]
+	}
+
+	return projectConfiguration, projectPlatform, nil
+}
+
+// projectsInBuildOrder returns the given projects and the projects they refer to (recursively),
+// every project is preceded by its references.
+func projectsInBuildOrder(sln solution.Model, roots []project.Model) ([]project.Model, []string) {
+	ordered := []project.Model{}
+	warnings := []string{}
+	visited := map[string]bool{}
+
+	var visit func(proj project.Model)
+	visit = func(proj project.Model) {
+		if visited[proj.ID] {
+			return
+		}
+		visited[proj.ID] = true
+
+		for _, projectID := range proj.ReferredProjectIDs {
+			referredProj, ok := sln.ProjectMap[projectID]
+			if !ok {
+				warnings = append(warnings, fmt.Sprintf("Project reference exist with project id: %s, but project not found in solution", projectID))
+				continue
+			}
+			visit(referredProj)
+		}
+
+		ordered = append(ordered, proj)
+	}
+
+	for _, root := range roots {
+		visit(root)
+	}
+
+	return ordered, warnings
+}
+
+// buildMixedSolution builds the Xamarin.UITest projects of the solution and their references project by project,
+// in dependency order: SDK-style projects are built with `dotnet msbuild`, classic projects with msbuild (or xbuild),
+// without letting them rebuild their project references, which are already built by then.
+// If testProjectPth is set, only that test project and its references are built.
+func buildMixedSolution(solutionPth, testProjectPth, configuration, platform string, buildTool buildtools.BuildTool, callback builder.BuildCommandCallback) ([]string, error) {
+	warnings := []string{}
+
+	sln, err := solution.New(solutionPth, true)
+	if err != nil {
+		return warnings, fmt.Errorf("Failed to analyze solution (%s), error: %s", solutionPth, err)
+	}
+
+	roots := []project.Model{}
+	if testProjectPth != "" {
+		absTestProjectPth, err := pathutil.AbsPath(testProjectPth)
+		if err != nil {
+			return warnings, fmt.Errorf("Failed to expand path (%s), error: %s", testProjectPth, err)
+		}
+
+		testProj, ok := solutionProject(sln, absTestProjectPth)
+		if !ok {
+			return warnings, fmt.Errorf("Project (%s) not found in solution (%s)", testProjectPth, solutionPth)
+		}
+		roots = append(roots, testProj)
+	} else {
+		for _, proj := range sln.ProjectMap {
+			if proj.TestFramework == constants.TestFrameworkXamarinUITest {
+				roots = append(roots, proj)
+			}
+		}
+	}
+	if len(roots) == 0 {
+		return warnings, fmt.Errorf("No Xamarin.UITest project to build found")
+	}
+
+	projects, warns := projectsInBuildOrder(sln, roots)
+	warnings = append(warnings, warns...)
+
+	for _, proj := range projects {
+		if proj.SDK == constants.SDKAndroid || proj.SDK == constants.SDKMacOS || proj.SDK == constants.SDKTvOS {
+			continue
+		}
+
+		sdkStyle, err := isSDKStyleProject(proj.Pth)
+		if err != nil {
+			return warnings, err
+		}
+
+		projectConfiguration, projectPlatform, err := splitProjectConfig(proj, configuration, platform)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s, skipping...", err))
+			continue
+		}
+
+		var buildCommand tools.Runnable
+		if sdkStyle {
+			cmd := &dotnetMsbuildModel{
+				solutionPth:   sln.Pth,
+				projectPth:    proj.Pth,
+				configuration: projectConfiguration,
+				platform:      projectPlatform,
+			}
+			cmd.SetCustomOptions("/p:BuildProjectReferences=false")
+			buildCommand = cmd
+		} else {
+			cmd, err := projectBuildCommand(sln.Pth, proj, configuration, platform, buildTool)
+			if err != nil {
+				return warnings, err
+			}
+			cmd.SetCustomOptions("/p:BuildProjectReferences=false")
+			buildCommand = cmd
+		}
+
+		if callback != nil {
+			callback(sln.Name, proj.Name, proj.SDK, proj.TestFramework, buildCommand.PrintableCommand(), false)
+		}
+
+		if err := buildCommand.Run(); err != nil {
+			return warnings, err
+		}
+	}
+
+	return warnings, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// utf16Reader converts UTF-16 encoded content to UTF-8.
+type utf16Reader struct {
+	reader    *bufio.Reader
+	byteOrder binary.ByteOrder
+	buffer    bytes.Buffer
+}
+
+func (reader *utf16Reader) readUnit() (uint16, error) {
+	var unit [2]byte
+	if _, err := io.ReadFull(reader.reader, unit[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, fmt.Errorf("truncated UTF-16 content")
+		}
+		return 0, err
+	}
+	return reader.byteOrder.Uint16(unit[:]), nil
+}
+
+// Read ...
+func (reader *utf16Reader) Read(p []byte) (int, error) {
+	for reader.buffer.Len() < len(p) {
+		unit, err := reader.readUnit()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+
+		r := rune(unit)
+		if utf16.IsSurrogate(r) {
+			next, err := reader.readUnit()
+			if err != nil && err != io.EOF {
+				return 0, err
+			}
+			r = utf16.DecodeRune(r, rune(next))
+		}
+
+		var encoded [utf8.UTFMax]byte
+		reader.buffer.Write(encoded[:utf8.EncodeRune(encoded[:], r)])
+	}
+
+	if reader.buffer.Len() == 0 {
+		return 0, io.EOF
+	}
+	return reader.buffer.Read(p)
+}
+
+// utf8Reader returns a reader of the UTF-8 content of the result file:
+// the UTF-8 byte order mark is skipped and UTF-16 content (with or without byte order mark) is converted to UTF-8.
+func utf8Reader(reader io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(reader)
+
+	head, err := buffered.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(head, utf8BOM):
+		_, err := buffered.Discard(len(utf8BOM))
+		return buffered, err
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}):
+		_, err := buffered.Discard(2)
+		return &utf16Reader{reader: buffered, byteOrder: binary.LittleEndian}, err
+	case bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		_, err := buffered.Discard(2)
+		return &utf16Reader{reader: buffered, byteOrder: binary.BigEndian}, err
+	case len(head) == 4 && head[0] == '<' && head[1] == 0 && head[2] != 0 && head[3] == 0:
+		return &utf16Reader{reader: buffered, byteOrder: binary.LittleEndian}, nil
+	case len(head) == 4 && head[0] == 0 && head[1] == '<' && head[2] == 0 && head[3] != 0:
+		return &utf16Reader{reader: buffered, byteOrder: binary.BigEndian}, nil
+	}
+	return buffered, nil
+}
+
+// xmlCharsetReader lets the xml decoder read the content converted to UTF-8 by utf8Reader,
+// even if the xml declaration states UTF-16 encoding.
+func xmlCharsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "utf-16", "utf16", "utf-16le", "utf-16be", "unicode", "us-ascii", "ascii":
+		return input, nil
+	}
+	return nil, fmt.Errorf("unsupported result file encoding: %s", charset)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// defaultEnvBytesLimitInKB is envman's default size limit of an environment variable's value.
+const defaultEnvBytesLimitInKB = 256
+
+// envmanConfigsModel is the envman config (~/.envman/configs.json).
+type envmanConfigsModel struct {
+	EnvBytesLimitInKB int `json:"env_bytes_limit_in_kb"`
+}
+
+// envmanValueSizeLimit returns the maximum size (in bytes) of an environment variable's value envman accepts.
+func envmanValueSizeLimit() int {
+	pth := filepath.Join(pathutil.UserHomeDir(), ".envman", "configs.json")
+	content, err := fileutil.ReadBytesFromFile(pth)
+	if err != nil {
+		return defaultEnvBytesLimitInKB * 1024
+	}
+
+	var configs envmanConfigsModel
+	if err := json.Unmarshal(content, &configs); err != nil || configs.EnvBytesLimitInKB <= 0 {
+		return defaultEnvBytesLimitInKB * 1024
+	}
+	return configs.EnvBytesLimitInKB * 1024
+}
+
+var passedTestCasePattern = regexp.MustCompile(`(?s)<test-case(\s[^>]*?\sresult="(?:Passed|Success)"(?:[^>]*[^/>])?)>.*?</test-case>`)
+
+// truncateResultsText fits the result xml into limit bytes: first the content (output, properties) of the passed test cases
+// is dropped, so the failures are kept, then the end of the xml is cut.
+// A truncated result xml ends with a truncated marker comment.
+func truncateResultsText(content string, limit int) string {
+	if len(content) <= limit {
+		return content
+	}
+
+	originalSize := len(content)
+	content = passedTestCasePattern.ReplaceAllString(content, "<test-case$1 />")
+
+	marker := func(size int) string {
+		return fmt.Sprintf("\n<!-- truncated: the content of the passed test cases is dropped, %d of %d bytes exported -->", size, originalSize)
+	}
+
+	if len(content)+len(marker(len(content))) > limit {
+		size := limit - len(marker(limit))
+		if size < 0 {
+			size = 0
+		}
+		content = content[:size]
+	}
+	return content + marker(len(content))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// systemLogExcerptLines is the number of the last simulator system log lines included in the failure evidence bundle.
+const systemLogExcerptLines = 2000
+
+// configsDump returns the step inputs as JSON, without the values of the test_env_vars and with the secrets masked.
+func configsDump(configs ConfigsModel) (string, error) {
+	configs.TestEnvVars = strings.Join(envKeys(splitLines(configs.TestEnvVars)), "\n")
+	content, err := json.MarshalIndent(configs, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return maskSecrets(string(content)), nil
+}
+
+func writeFileTail(srcPth, dstPth string, lineCount int) error {
+	content, err := fileutil.ReadStringFromFile(srcPth)
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteStringToFile(dstPth, maskSecrets(truncateLeadingLines(content, lineCount)))
+}
+
+// truncateLeadingLines keeps the last lineCount lines of the text.
+func truncateLeadingLines(text string, lineCount int) string {
+	lines := strings.Split(text, "\n")
+	if len(lines) <= lineCount {
+		return text
+	}
+	return strings.Join(lines[len(lines)-lineCount:], "\n")
+}
+
+// testRunEvidencePths returns the result xml, the screenshots, the test attachments, the NUnit trace logs
+// and the crash reports of the test run.
+func testRunEvidencePths(testRun TestRunModel) []string {
+	pths := []string{testRun.ResultLogPth}
+
+	deployedPths := []string{}
+	for _, pth := range testRun.DeployedFiles {
+		deployedPths = append(deployedPths, pth)
+	}
+	sort.Strings(deployedPths)
+	pths = append(pths, deployedPths...)
+
+	return append(pths, testRun.EvidencePths...)
+}
+
+// exportFailureEvidence zips the files needed for the triage of the failed test runs into the deploy dir:
+// per failed test run a dir with the result xml, the screenshots, the attachments, the NUnit trace logs,
+// the crash reports and the end of the simulator system log, and the step inputs.
+func exportFailureEvidence(configs ConfigsModel, testRuns []TestRunModel) (string, error) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("failure-evidence")
+	if err != nil {
+		return "", fmt.Errorf("Failed to create temp dir, error: %s", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			log.Warnf("Failed to remove temp dir (%s), error: %s", tmpDir, err)
+		}
+	}()
+
+	bundleDir := filepath.Join(tmpDir, "failure-evidence")
+
+	for _, testRun := range testRuns {
+		if testRun.Err == nil {
+			continue
+		}
+
+		runDir := filepath.Join(bundleDir, sanitizedFileName(testRun.FullName()))
+		if err := pathutil.EnsureDirExist(runDir); err != nil {
+			return "", fmt.Errorf("Failed to create dir (%s), error: %s", runDir, err)
+		}
+
+		if err := fileutil.WriteStringToFile(filepath.Join(runDir, "error.txt"), maskSecrets(testRun.Err.Error())+"\n"); err != nil {
+			return "", fmt.Errorf("Failed to write test run error, error: %s", err)
+		}
+
+		for _, pth := range testRunEvidencePths(testRun) {
+			if exist, err := pathutil.IsPathExists(pth); err != nil {
+				return "", fmt.Errorf("Failed to check if file (%s) exist, error: %s", pth, err)
+			} else if !exist {
+				continue
+			}
+
+			dst := filepath.Join(runDir, filepath.Base(pth))
+			if relPth, err := filepath.Rel(configs.DeployDir, pth); err == nil && !strings.HasPrefix(relPth, "..") {
+				dst = filepath.Join(runDir, relPth)
+				if err := pathutil.EnsureDirExist(filepath.Dir(dst)); err != nil {
+					return "", fmt.Errorf("Failed to create dir (%s), error: %s", filepath.Dir(dst), err)
+				}
+			}
+			if err := command.CopyFile(pth, dst); err != nil {
+				return "", fmt.Errorf("Failed to copy file (%s), error: %s", pth, err)
+			}
+		}
+
+		if testRun.SystemLogPth != "" {
+			if err := writeFileTail(testRun.SystemLogPth, filepath.Join(runDir, "system-log-excerpt.log"), systemLogExcerptLines); err != nil {
+				log.Warnf("Failed to write simulator system log excerpt, error: %s", err)
+			}
+		}
+	}
+
+	if err := pathutil.EnsureDirExist(bundleDir); err != nil {
+		return "", fmt.Errorf("Failed to create dir (%s), error: %s", bundleDir, err)
+	}
+	dump, err := configsDump(configs)
+	if err != nil {
+		return "", fmt.Errorf("Failed to serialize the step inputs, error: %s", err)
+	}
+	if err := fileutil.WriteStringToFile(filepath.Join(bundleDir, "step-config.json"), dump); err != nil {
+		return "", fmt.Errorf("Failed to write the step inputs, error: %s", err)
+	}
+
+	zipPth := filepath.Join(configs.DeployDir, "failure-evidence.zip")
+	if err := zipDir(bundleDir, zipPth); err != nil {
+		return "", err
+	}
+	return zipPth, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-tools/go-steputils/tools"
+	"github.com/bitrise-tools/go-xamarin/builder"
+)
+
+// TestExplorer lists the tests of a test assembly without running them.
+type TestExplorer interface {
+	// Explore writes the full names of the selected test cases into listPth, one per line.
+	Explore(dllPth, testToRun, listPth string) error
+}
+
+// Explore ...
+func (runner nunit3Runner) Explore(dllPth, testToRun, listPth string) error {
+	cmdSlice := append(runner.commandSlice([]string{dllPth}, testToRun, ""), fmt.Sprintf("--explore=%s;format=cases", listPth))
+	return runTestCommand("Exploring Xamarin UITest", cmdSlice, runner.envs)
+}
+
+// exploreTestNames returns the full names of the test cases of the test assembly, selected by testToRun and the runner's filter.
+func exploreTestNames(explorer TestExplorer, dllPth, testToRun, listPth string) ([]string, error) {
+	if err := explorer.Explore(dllPth, testToRun, listPth); err != nil {
+		return nil, err
+	}
+
+	content, err := fileutil.ReadStringFromFile(listPth)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read test list, error: %s", err)
+	}
+
+	names := []string{}
+	for _, name := range strings.Split(content, "\n") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// listTests lists the tests of every test project without running them,
+// writes them into the deploy dir and exports the list's path and the number of tests.
+func listTests(configs ConfigsModel, runners *TestRunnersModel, testProjectOutputMap builder.TestProjectOutputMap) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("test-list")
+	if err != nil {
+		failf("Failed to create tmp dir, error: %s", err)
+	}
+
+	testNames := []string{}
+	listed := map[string]bool{}
+
+	for testProjectName, testProjectOutput := range testProjectOutputMap {
+		runner, err := runners.ForTestProject(testProjectName, testProjectOutput.Output.Pth)
+		if err != nil {
+			failf("Failed to select test runner, error: %s", err)
+		}
+
+		explorer, ok := runner.(TestExplorer)
+		if !ok {
+			log.Warnf("Listing tests is only supported with nunit3-console, skipping test project (%s)...", testProjectName)
+			continue
+		}
+
+		listPth := filepath.Join(tmpDir, sanitizedFileName(testProjectName)+".txt")
+		names, err := exploreTestNames(explorer, testProjectOutput.Output.Pth, configs.TestToRun, listPth)
+		if err != nil {
+			failf("Failed to list the tests of (%s), error: %s", testProjectName, err)
+		}
+
+		for _, name := range names {
+			if !listed[name] {
+				listed[name] = true
+				testNames = append(testNames, name)
+			}
+		}
+	}
+
+	testListPth := filepath.Join(configs.DeployDir, "test_list.txt")
+	if err := fileutil.WriteStringToFile(testListPth, strings.Join(testNames, "\n")+"\n"); err != nil {
+		failf("Failed to write test list, error: %s", err)
+	}
+
+	fmt.Println()
+	log.Donef("%d test(s) found, the list is written to: %s", len(testNames), testListPth)
+
+	if err := tools.ExportEnvironmentWithEnvman("BITRISE_XAMARIN_TEST_LIST_PATH", testListPth); err != nil {
+		log.Warnf("Failed to export environment: %s, error: %s", "BITRISE_XAMARIN_TEST_LIST_PATH", err)
+	}
+	if err := tools.ExportEnvironmentWithEnvman("BITRISE_XAMARIN_TEST_COUNT", strconv.Itoa(len(testNames))); err != nil {
+		log.Warnf("Failed to export environment: %s, error: %s", "BITRISE_XAMARIN_TEST_COUNT", err)
+	}
+}
+
+// checkTestSelection runs a dry run (nunit3-console --explore) of the test selection on every test project after the build,
+// so a test selection rejected by nunit3-console, or selecting no tests, fails the step before running the tests.
+func checkTestSelection(configs ConfigsModel, runners *TestRunnersModel, testProjectOutputMap builder.TestProjectOutputMap) {
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("test-selection")
+	if err != nil {
+		failf("Failed to create tmp dir, error: %s", err)
+	}
+
+	fmt.Println()
+	log.Infof("Checking the test selection")
+
+	explored := 0
+	total := 0
+	for testProjectName, testProjectOutput := range testProjectOutputMap {
+		runner, err := runners.ForTestProject(testProjectName, testProjectOutput.Output.Pth)
+		if err != nil {
+			failf("Failed to select test runner, error: %s", err)
+		}
+
+		explorer, ok := runner.(TestExplorer)
+		if !ok {
+			log.Warnf("Checking the test selection is only supported with nunit3-console, skipping test project (%s)...", testProjectName)
+			continue
+		}
+
+		listPth := filepath.Join(tmpDir, sanitizedFileName(testProjectName)+".txt")
+		names, err := exploreTestNames(explorer, testProjectOutput.Output.Pth, configs.TestToRun, listPth)
+		if err != nil {
+			failf("Invalid test selection for test project (%s), error: %s\nCheck the test_to_run, test_filter and category inputs, see: %s", testProjectName, err, testSelectionDocsURL)
+		}
+
+		log.Printf("%s: %d test(s) selected", testProjectName, len(names))
+		explored++
+		total += len(names)
+	}
+
+	if explored == 0 {
+		log.Warnf("None of the test projects could be checked")
+		return
+	}
+	if total == 0 {
+		message := fmt.Sprintf("The test selection does not select any test, see: %s", testSelectionDocsURL)
+		if configs.NoTestsBehavior == "fail" {
+			failf("%s", message)
+		}
+		log.Warnf("%s", message)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// testSelectionDocsURL documents the NUnit3 test selection language, used by both the --where and the --test options.
+const testSelectionDocsURL = "https://docs.nunit.org/articles/nunit/running-tests/Test-Selection-Language.html"
+
+// filterToken is a token of an NUnit3 test selection (--where) expression.
+type filterToken struct {
+	kind  string // "word", "value", "op", "and", "or", "not", "(", ")"
+	value string
+}
+
+func isFilterWordChar(c byte) bool {
+	return c == '_' || c == '.' || c == '-' || c == '+' || c == '*' || c == '`' || c == ',' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// tokenizeFilter splits the expression into tokens,
+// quoted ('...', "...") and regex (/.../) values are kept together.
+func tokenizeFilter(expr string) ([]filterToken, error) {
+	tokens := []filterToken{}
+
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, filterToken{kind: string(c), value: string(c)})
+			i++
+		case strings.HasPrefix(expr[i:], "&&"):
+			tokens = append(tokens, filterToken{kind: "and", value: "&&"})
+			i += 2
+		case strings.HasPrefix(expr[i:], "||"):
+			tokens = append(tokens, filterToken{kind: "or", value: "||"})
+			i += 2
+		case strings.HasPrefix(expr[i:], "=="), strings.HasPrefix(expr[i:], "!="),
+			strings.HasPrefix(expr[i:], "=~"), strings.HasPrefix(expr[i:], "!~"):
+			tokens = append(tokens, filterToken{kind: "op", value: expr[i : i+2]})
+			i += 2
+		case c == '=':
+			tokens = append(tokens, filterToken{kind: "op", value: "="})
+			i++
+		case c == '!':
+			tokens = append(tokens, filterToken{kind: "not", value: "!"})
+			i++
+		case c == '\'' || c == '"' || c == '/':
+			end := i + 1
+			for end < len(expr) && expr[end] != c {
+				if expr[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(expr) {
+				return nil, fmt.Errorf("unterminated value starting at position %d: %s", i, expr[i:])
+			}
+			tokens = append(tokens, filterToken{kind: "value", value: expr[i : end+1]})
+			i = end + 1
+		case isFilterWordChar(c):
+			end := i
+			for end < len(expr) && isFilterWordChar(expr[end]) {
+				end++
+			}
+			word := expr[i:end]
+			switch strings.ToLower(word) {
+			case "and":
+				tokens = append(tokens, filterToken{kind: "and", value: word})
+			case "or":
+				tokens = append(tokens, filterToken{kind: "or", value: word})
+			case "not":
+				tokens = append(tokens, filterToken{kind: "not", value: word})
+			default:
+				tokens = append(tokens, filterToken{kind: "word", value: word})
+			}
+			i = end
+		default:
+			return nil, fmt.Errorf("unexpected character (%c) at position %d", c, i)
+		}
+	}
+
+	return tokens, nil
+}
+
+// filterParser checks the structure of an NUnit3 test selection expression:
+//
+//	expression := term { ("||" | "or") term }
+//	term       := factor { ("&&" | "and") factor }
+//	factor     := ("!" | "not") factor | "(" expression ")" | key operator value
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+func (parser *filterParser) peek() (filterToken, bool) {
+	if parser.pos >= len(parser.tokens) {
+		return filterToken{}, false
+	}
+	return parser.tokens[parser.pos], true
+}
+
+func (parser *filterParser) next() (filterToken, bool) {
+	token, ok := parser.peek()
+	if ok {
+		parser.pos++
+	}
+	return token, ok
+}
+
+func (parser *filterParser) expression() error {
+	if err := parser.term(); err != nil {
+		return err
+	}
+	for {
+		if token, ok := parser.peek(); !ok || token.kind != "or" {
+			return nil
+		}
+		parser.pos++
+		if err := parser.term(); err != nil {
+			return err
+		}
+	}
+}
+
+func (parser *filterParser) term() error {
+	if err := parser.factor(); err != nil {
+		return err
+	}
+	for {
+		if token, ok := parser.peek(); !ok || token.kind != "and" {
+			return nil
+		}
+		parser.pos++
+		if err := parser.factor(); err != nil {
+			return err
+		}
+	}
+}
+
+func (parser *filterParser) factor() error {
+	token, ok := parser.next()
+	if !ok {
+		return fmt.Errorf("unexpected end of expression")
+	}
+
+	switch token.kind {
+	case "not":
+		return parser.factor()
+	case "(":
+		if err := parser.expression(); err != nil {
+			return err
+		}
+		if closing, ok := parser.next(); !ok || closing.kind != ")" {
+			return fmt.Errorf("missing closing parenthesis")
+		}
+		return nil
+	case "word":
+		operator, ok := parser.next()
+		if !ok || operator.kind != "op" {
+			return fmt.Errorf("missing operator after: %s", token.value)
+		}
+		value, ok := parser.next()
+		if !ok || (value.kind != "word" && value.kind != "value") {
+			return fmt.Errorf("missing value after: %s %s", token.value, operator.value)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unexpected token: %s", token.value)
+	}
+}
+
+// validateFilterExpression checks whether the expression is a well-formed NUnit3 test selection (--where) expression.
+func validateFilterExpression(expr string) error {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return err
+	}
+	if len(tokens) == 0 {
+		return fmt.Errorf("empty expression")
+	}
+
+	parser := filterParser{tokens: tokens}
+	if err := parser.expression(); err != nil {
+		return err
+	}
+	if token, ok := parser.peek(); ok {
+		return fmt.Errorf("unexpected token: %s", token.value)
+	}
+	return nil
+}
+
+// validateTestNames checks whether the names of the test_to_run input have balanced parentheses and closed quotes.
+func validateTestNames(testToRun string) error {
+	for _, name := range splitTestNames(testToRun) {
+		depth := 0
+		var quote rune
+		for _, r := range name {
+			switch {
+			case quote != 0:
+				if r == quote {
+					quote = 0
+				}
+			case r == '"' || r == '\'':
+				quote = r
+			case r == '(':
+				depth++
+			case r == ')':
+				depth--
+				if depth < 0 {
+					return fmt.Errorf("unexpected closing parenthesis in test name: %s", name)
+				}
+			}
+		}
+		if quote != 0 {
+			return fmt.Errorf("unterminated quote in test name: %s", name)
+		}
+		if depth > 0 {
+			return fmt.Errorf("missing closing parenthesis in test name: %s", name)
+		}
+	}
+	return nil
+}
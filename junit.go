@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// JUnitFailureModel ...
+type JUnitFailureModel struct {
+	Message string `xml:"message,attr,omitempty"`
+	Value   string `xml:",chardata"`
+}
+
+// JUnitSkippedModel ...
+type JUnitSkippedModel struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+// JUnitTestCaseModel ...
+type JUnitTestCaseModel struct {
+	XMLName   xml.Name           `xml:"testcase"`
+	Name      string             `xml:"name,attr"`
+	ClassName string             `xml:"classname,attr"`
+	Time      float64            `xml:"time,attr"`
+	Failure   *JUnitFailureModel `xml:"failure,omitempty"`
+	Skipped   *JUnitSkippedModel `xml:"skipped,omitempty"`
+}
+
+// JUnitTestSuiteModel ...
+type JUnitTestSuiteModel struct {
+	XMLName   xml.Name             `xml:"testsuite"`
+	Name      string               `xml:"name,attr"`
+	Tests     int                  `xml:"tests,attr"`
+	Failures  int                  `xml:"failures,attr"`
+	Skipped   int                  `xml:"skipped,attr"`
+	Time      float64              `xml:"time,attr"`
+	TestCases []JUnitTestCaseModel `xml:"testcase"`
+}
+
+// JUnitTestSuitesModel ...
+type JUnitTestSuitesModel struct {
+	XMLName    xml.Name              `xml:"testsuites"`
+	Name       string                `xml:"name,attr,omitempty"`
+	Tests      int                   `xml:"tests,attr"`
+	Failures   int                   `xml:"failures,attr"`
+	Skipped    int                   `xml:"skipped,attr"`
+	Time       float64               `xml:"time,attr"`
+	TestSuites []JUnitTestSuiteModel `xml:"testsuite"`
+}
+
+// convertToJUnit converts the NUnit test results to JUnit test suites, one test suite per fixture.
+func convertToJUnit(name string, results TestResultsModel) JUnitTestSuitesModel {
+	testSuites := JUnitTestSuitesModel{Name: name}
+	suiteIdxByFixture := map[string]int{}
+
+	for _, testCase := range results.TestCases {
+		fixture := testCase.Fixture()
+
+		idx, ok := suiteIdxByFixture[fixture]
+		if !ok {
+			idx = len(testSuites.TestSuites)
+			suiteIdxByFixture[fixture] = idx
+			testSuites.TestSuites = append(testSuites.TestSuites, JUnitTestSuiteModel{Name: fixture})
+		}
+		testSuite := &testSuites.TestSuites[idx]
+
+		junitTestCase := JUnitTestCaseModel{
+			Name:      testCase.Name,
+			ClassName: fixture,
+			Time:      testCase.Duration,
+		}
+
+		switch testCase.Result {
+		case testResultFailed:
+			failure := &JUnitFailureModel{}
+			if testCase.Failure != nil {
+				failure.Message = maskSecrets(strings.TrimSpace(testCase.Failure.Message))
+				failure.Value = maskSecrets(strings.TrimSpace(testCase.Failure.StackTrace))
+			}
+			junitTestCase.Failure = failure
+			testSuite.Failures++
+		case testResultSkipped, testResultInconclusive:
+			skipped := &JUnitSkippedModel{}
+			if testCase.Reason != nil {
+				skipped.Message = maskSecrets(strings.TrimSpace(testCase.Reason.Message))
+			}
+			junitTestCase.Skipped = skipped
+			testSuite.Skipped++
+		}
+
+		testSuite.Tests++
+		testSuite.Time += testCase.Duration
+		testSuite.TestCases = append(testSuite.TestCases, junitTestCase)
+
+		testSuites.Tests++
+		testSuites.Time += testCase.Duration
+		if junitTestCase.Failure != nil {
+			testSuites.Failures++
+		}
+		if junitTestCase.Skipped != nil {
+			testSuites.Skipped++
+		}
+	}
+
+	return testSuites
+}
+
+func writeJUnitResults(pth, name string, results TestResultsModel) error {
+	content, err := xml.MarshalIndent(convertToJUnit(name, results), "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to serialize JUnit results, error: %s", err)
+	}
+
+	return fileutil.WriteStringToFile(pth, xml.Header+string(content))
+}
+
+func sanitizedFileName(name string) string {
+	return strings.Trim(regexp.MustCompile(`[^a-zA-Z0-9._-]+`).ReplaceAllString(name, "_"), "_")
+}
+
+// exportToTestResultDir writes the test results in the format expected by the Bitrise Test Reports add-on:
+// a JUnit xml and a test-info.json in a dedicated directory in the test result dir.
+func exportToTestResultDir(testResultDir, testName string, results TestResultsModel) error {
+	testDir := filepath.Join(testResultDir, sanitizedFileName(testName))
+	if err := pathutil.EnsureDirExist(testDir); err != nil {
+		return fmt.Errorf("Failed to create dir (%s), error: %s", testDir, err)
+	}
+
+	if err := writeJUnitResults(filepath.Join(testDir, "TestResult.xml"), testName, results); err != nil {
+		return err
+	}
+
+	testInfo, err := json.Marshal(map[string]string{"test-name": testName})
+	if err != nil {
+		return fmt.Errorf("Failed to serialize test info, error: %s", err)
+	}
+
+	return fileutil.WriteBytesToFile(filepath.Join(testDir, "test-info.json"), testInfo)
+}
@@ -1,52 +1,223 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/command"
 	"github.com/bitrise-io/go-utils/log"
 	"github.com/bitrise-io/go-utils/pathutil"
 	"github.com/bitrise-tools/go-steputils/input"
 	"github.com/bitrise-tools/go-steputils/tools"
+	"github.com/bitrise-tools/go-xamarin/analyzers/solution"
 	"github.com/bitrise-tools/go-xamarin/builder"
 	"github.com/bitrise-tools/go-xamarin/constants"
 	"github.com/bitrise-tools/go-xamarin/tools/buildtools"
-	"github.com/bitrise-tools/go-xamarin/tools/nunit"
 	"github.com/bitrise-tools/go-xcode/simulator"
 	"github.com/hashicorp/go-version"
 )
 
 // ConfigsModel ...
 type ConfigsModel struct {
-	SimulatorDevice    string
-	SimulatorOsVersion string
-	TestToRun          string
+	SimulatorDevice       string
+	SimulatorUDID         string
+	SimulatorOsVersion    string
+	TestToRun             string
+	TestFilter            string
+	IncludeCategories     string
+	ExcludeCategories     string
+	DeviceOnlyCategories  string
+	TestListPath          string
+	BaselineResultPath    string
+	FailOnNewFailuresOnly string
+	NunitWorkers          string
+	NunitLabels           string
+	TestTimeoutSeconds    string
+	StopOnFirstFailure    string
+	TestParams            string
+	NunitAgentArch        string
+	NunitProcess          string
+	NunitDomain           string
+	NunitTrace            string
+	NunitAgents           string
+	CombineTestAssemblies string
+	NunitWorkDir          string
+	TestOrder             string
+	TestSeed              string
+	ResultFormat          string
+	ExportJUnitResults    string
+	ExportTRXResults      string
+	ExportFullResultsText string
+	NunitExtraOptions     string
+	TestEnvVars           string
+	SecretEnvKeys         string
+	NunitConsolePath      string
+	MinimumNunitVersion   string
+	Nunit2ConsolePath     string
+	XunitConsolePath      string
+	TestRunner            string
+	ListTestsOnly         string
+	CheckTestSelection    string
+	TestProjectsToRun     string
+	TestProjectFilter     string
 
 	XamarinSolution      string
 	XamarinConfiguration string
 	XamarinPlatform      string
 
-	BuildTool string
-	DeployDir string
+	NoTestProjectsBehavior      string
+	NoTestsBehavior             string
+	MinFreeDiskSpaceGB          string
+	LowDiskSpaceBehavior        string
+	RetryFailedTestsCount       string
+	FailOnFlakyTests            string
+	RepeatCount                 string
+	RepeatStopOnFailure         string
+	ContinueOnFailure           string
+	StackTraceLineLimit         string
+	ErrorMessageFailureLimit    string
+	SlowTestThresholdSeconds    string
+	RecordVideo                 string
+	CaptureSimulatorLog         string
+	CollectSimulatorDiagnostics string
+	ExportAppDataContainer      string
+	ExportFailureEvidence       string
+	ReinstallApp                string
+	AdditionalAppPaths          string
+	WarmUpApp                   string
+	GrantPermissions            string
+	PushPayloads                string
+	CreateSimulatorIfMissing    string
+	CleanupStaleSimulators      string
+	EraseSimulator              string
+	KeepSimulatorAlive          string
+	SimulatorBootAttempts       string
+	SimulatorBootRetryDelay     string
+	SnapshotSimulator           string
+	UseRAMDisk                  string
+	RAMDiskSizeMB               string
+	SimulatorLanguage           string
+	DisconnectHardwareKeyboard  string
+	SimulatorLocale             string
+	SimulatorTimezone           string
+	InterfaceStyle              string
+	OverrideStatusBar           string
+	SimulatorLatitude           string
+	SimulatorLongitude          string
+
+	BuildTool         string
+	MonoPath          string
+	MonoVersion       string
+	XcodeDeveloperDir string
+	SimctlTimeout     string
+	DeployDir         string
+	TestResultDir     string
 }
 
 func createConfigsModelFromEnvs() ConfigsModel {
 	return ConfigsModel{
-		SimulatorDevice:    os.Getenv("simulator_device"),
-		SimulatorOsVersion: os.Getenv("simulator_os_version"),
-		TestToRun:          os.Getenv("test_to_run"),
+		SimulatorDevice:       os.Getenv("simulator_device"),
+		SimulatorUDID:         os.Getenv("simulator_udid"),
+		SimulatorOsVersion:    os.Getenv("simulator_os_version"),
+		TestToRun:             os.Getenv("test_to_run"),
+		TestFilter:            os.Getenv("test_filter"),
+		IncludeCategories:     os.Getenv("include_categories"),
+		ExcludeCategories:     os.Getenv("exclude_categories"),
+		DeviceOnlyCategories:  os.Getenv("device_only_categories"),
+		TestListPath:          os.Getenv("test_list_path"),
+		BaselineResultPath:    os.Getenv("baseline_result_path"),
+		FailOnNewFailuresOnly: os.Getenv("fail_on_new_failures_only"),
+		NunitWorkers:          os.Getenv("nunit_workers"),
+		NunitLabels:           os.Getenv("nunit_labels"),
+		TestTimeoutSeconds:    os.Getenv("test_timeout_seconds"),
+		StopOnFirstFailure:    os.Getenv("stop_on_first_failure"),
+		TestParams:            os.Getenv("test_params"),
+		NunitAgentArch:        os.Getenv("nunit_agent_arch"),
+		NunitProcess:          os.Getenv("nunit_process"),
+		NunitDomain:           os.Getenv("nunit_domain"),
+		NunitTrace:            os.Getenv("nunit_trace"),
+		NunitAgents:           os.Getenv("nunit_agents"),
+		CombineTestAssemblies: os.Getenv("combine_test_assemblies"),
+		NunitWorkDir:          os.Getenv("nunit_work_dir"),
+		TestOrder:             os.Getenv("test_order"),
+		TestSeed:              os.Getenv("test_seed"),
+		ResultFormat:          os.Getenv("result_format"),
+		ExportJUnitResults:    os.Getenv("export_junit_results"),
+		ExportTRXResults:      os.Getenv("export_trx_results"),
+		ExportFullResultsText: os.Getenv("export_full_results_text"),
+		NunitExtraOptions:     os.Getenv("nunit_extra_options"),
+		TestEnvVars:           os.Getenv("test_env_vars"),
+		SecretEnvKeys:         os.Getenv("secret_env_keys"),
+		NunitConsolePath:      os.Getenv("nunit_console_path"),
+		MinimumNunitVersion:   os.Getenv("minimum_nunit_version"),
+		Nunit2ConsolePath:     os.Getenv("nunit2_console_path"),
+		XunitConsolePath:      os.Getenv("xunit_console_path"),
+		TestRunner:            os.Getenv("test_runner"),
+		ListTestsOnly:         os.Getenv("list_tests_only"),
+		CheckTestSelection:    os.Getenv("check_test_selection"),
+		TestProjectsToRun:     os.Getenv("test_projects_to_run"),
+		TestProjectFilter:     os.Getenv("test_project_filter"),
 
 		XamarinSolution:      os.Getenv("xamarin_project"),
 		XamarinConfiguration: os.Getenv("xamarin_configuration"),
 		XamarinPlatform:      os.Getenv("xamarin_platform"),
 
-		BuildTool: os.Getenv("build_tool"),
-		DeployDir: os.Getenv("BITRISE_DEPLOY_DIR"),
+		NoTestProjectsBehavior:      os.Getenv("no_test_projects_behavior"),
+		NoTestsBehavior:             os.Getenv("no_tests_behavior"),
+		MinFreeDiskSpaceGB:          os.Getenv("min_free_disk_space_gb"),
+		LowDiskSpaceBehavior:        os.Getenv("low_disk_space_behavior"),
+		RetryFailedTestsCount:       os.Getenv("retry_failed_tests_count"),
+		FailOnFlakyTests:            os.Getenv("fail_on_flaky_tests"),
+		RepeatCount:                 os.Getenv("repeat_count"),
+		RepeatStopOnFailure:         os.Getenv("repeat_stop_on_failure"),
+		ContinueOnFailure:           os.Getenv("continue_on_failure"),
+		StackTraceLineLimit:         os.Getenv("stack_trace_line_limit"),
+		ErrorMessageFailureLimit:    os.Getenv("error_message_failure_limit"),
+		SlowTestThresholdSeconds:    os.Getenv("slow_test_threshold_seconds"),
+		RecordVideo:                 os.Getenv("record_video"),
+		CaptureSimulatorLog:         os.Getenv("capture_simulator_log"),
+		CollectSimulatorDiagnostics: os.Getenv("collect_simulator_diagnostics"),
+		ExportAppDataContainer:      os.Getenv("export_app_data_container"),
+		ExportFailureEvidence:       os.Getenv("export_failure_evidence"),
+		ReinstallApp:                os.Getenv("reinstall_app"),
+		AdditionalAppPaths:          os.Getenv("additional_app_paths"),
+		WarmUpApp:                   os.Getenv("warm_up_app"),
+		GrantPermissions:            os.Getenv("grant_permissions"),
+		PushPayloads:                os.Getenv("push_payloads"),
+		CreateSimulatorIfMissing:    os.Getenv("create_simulator_if_missing"),
+		CleanupStaleSimulators:      os.Getenv("cleanup_stale_simulators"),
+		EraseSimulator:              os.Getenv("erase_simulator"),
+		KeepSimulatorAlive:          os.Getenv("keep_simulator_alive"),
+		SimulatorBootAttempts:       os.Getenv("simulator_boot_attempts"),
+		SimulatorBootRetryDelay:     os.Getenv("simulator_boot_retry_delay"),
+		SnapshotSimulator:           os.Getenv("snapshot_simulator"),
+		UseRAMDisk:                  os.Getenv("use_ram_disk"),
+		RAMDiskSizeMB:               os.Getenv("ram_disk_size_mb"),
+		SimulatorLanguage:           os.Getenv("simulator_language"),
+		DisconnectHardwareKeyboard:  os.Getenv("disconnect_hardware_keyboard"),
+		SimulatorLocale:             os.Getenv("simulator_locale"),
+		SimulatorTimezone:           os.Getenv("simulator_timezone"),
+		InterfaceStyle:              os.Getenv("interface_style"),
+		OverrideStatusBar:           os.Getenv("override_status_bar"),
+		SimulatorLatitude:           os.Getenv("simulator_latitude"),
+		SimulatorLongitude:          os.Getenv("simulator_longitude"),
+
+		BuildTool:         os.Getenv("build_tool"),
+		MonoPath:          os.Getenv("mono_path"),
+		MonoVersion:       os.Getenv("mono_version"),
+		XcodeDeveloperDir: os.Getenv("xcode_developer_dir"),
+		SimctlTimeout:     os.Getenv("simctl_timeout"),
+		DeployDir:         os.Getenv("BITRISE_DEPLOY_DIR"),
+		TestResultDir:     os.Getenv("BITRISE_TEST_RESULT_DIR"),
 	}
 }
 
@@ -54,30 +225,265 @@ func (configs ConfigsModel) print() {
 	log.Infof("Testing:")
 
 	log.Printf("- SimulatorDevice: %s", configs.SimulatorDevice)
+	log.Printf("- SimulatorUDID: %s", configs.SimulatorUDID)
 	log.Printf("- SimulatorOsVersion: %s", configs.SimulatorOsVersion)
 	log.Printf("- TestToRun: %s", configs.TestToRun)
+	log.Printf("- TestFilter: %s", configs.TestFilter)
+	log.Printf("- IncludeCategories: %s", configs.IncludeCategories)
+	log.Printf("- ExcludeCategories: %s", configs.ExcludeCategories)
+	log.Printf("- DeviceOnlyCategories: %s", configs.DeviceOnlyCategories)
+	log.Printf("- TestListPath: %s", configs.TestListPath)
+	log.Printf("- BaselineResultPath: %s", configs.BaselineResultPath)
+	log.Printf("- FailOnNewFailuresOnly: %s", configs.FailOnNewFailuresOnly)
+	log.Printf("- NunitWorkers: %s", configs.NunitWorkers)
+	log.Printf("- NunitLabels: %s", configs.NunitLabels)
+	log.Printf("- TestTimeoutSeconds: %s", configs.TestTimeoutSeconds)
+	log.Printf("- StopOnFirstFailure: %s", configs.StopOnFirstFailure)
+	log.Printf("- TestParams: %s", configs.TestParams)
+	log.Printf("- NunitAgentArch: %s", configs.NunitAgentArch)
+	log.Printf("- NunitProcess: %s", configs.NunitProcess)
+	log.Printf("- NunitDomain: %s", configs.NunitDomain)
+	log.Printf("- NunitTrace: %s", configs.NunitTrace)
+	log.Printf("- NunitAgents: %s", configs.NunitAgents)
+	log.Printf("- CombineTestAssemblies: %s", configs.CombineTestAssemblies)
+	log.Printf("- NunitWorkDir: %s", configs.NunitWorkDir)
+	log.Printf("- TestOrder: %s", configs.TestOrder)
+	log.Printf("- TestSeed: %s", configs.TestSeed)
+	log.Printf("- ResultFormat: %s", configs.ResultFormat)
+	log.Printf("- ExportJUnitResults: %s", configs.ExportJUnitResults)
+	log.Printf("- ExportTRXResults: %s", configs.ExportTRXResults)
+	log.Printf("- ExportFullResultsText: %s", configs.ExportFullResultsText)
+	log.Printf("- NunitExtraOptions: %s", configs.NunitExtraOptions)
+	log.Printf("- TestEnvVars: %s", strings.Join(envKeys(splitLines(configs.TestEnvVars)), ", "))
+	log.Printf("- SecretEnvKeys: %s", configs.SecretEnvKeys)
+	log.Printf("- NunitConsolePath: %s", configs.NunitConsolePath)
+	log.Printf("- MinimumNunitVersion: %s", configs.MinimumNunitVersion)
+	log.Printf("- Nunit2ConsolePath: %s", configs.Nunit2ConsolePath)
+	log.Printf("- XunitConsolePath: %s", configs.XunitConsolePath)
+	log.Printf("- TestRunner: %s", configs.TestRunner)
+	log.Printf("- ListTestsOnly: %s", configs.ListTestsOnly)
+	log.Printf("- CheckTestSelection: %s", configs.CheckTestSelection)
+	log.Printf("- TestProjectsToRun: %s", configs.TestProjectsToRun)
+	log.Printf("- TestProjectFilter: %s", configs.TestProjectFilter)
 
 	log.Infof("Configs:")
 
 	log.Printf("- XamarinSolution: %s", configs.XamarinSolution)
 	log.Printf("- XamarinConfiguration: %s", configs.XamarinConfiguration)
 	log.Printf("- XamarinPlatform: %s", configs.XamarinPlatform)
+	log.Printf("- NoTestProjectsBehavior: %s", configs.NoTestProjectsBehavior)
+	log.Printf("- NoTestsBehavior: %s", configs.NoTestsBehavior)
+	log.Printf("- MinFreeDiskSpaceGB: %s", configs.MinFreeDiskSpaceGB)
+	log.Printf("- LowDiskSpaceBehavior: %s", configs.LowDiskSpaceBehavior)
+	log.Printf("- RetryFailedTestsCount: %s", configs.RetryFailedTestsCount)
+	log.Printf("- FailOnFlakyTests: %s", configs.FailOnFlakyTests)
+	log.Printf("- RepeatCount: %s", configs.RepeatCount)
+	log.Printf("- RepeatStopOnFailure: %s", configs.RepeatStopOnFailure)
+	log.Printf("- ContinueOnFailure: %s", configs.ContinueOnFailure)
+	log.Printf("- StackTraceLineLimit: %s", configs.StackTraceLineLimit)
+	log.Printf("- ErrorMessageFailureLimit: %s", configs.ErrorMessageFailureLimit)
+	log.Printf("- SlowTestThresholdSeconds: %s", configs.SlowTestThresholdSeconds)
+	log.Printf("- RecordVideo: %s", configs.RecordVideo)
+	log.Printf("- CaptureSimulatorLog: %s", configs.CaptureSimulatorLog)
+	log.Printf("- CollectSimulatorDiagnostics: %s", configs.CollectSimulatorDiagnostics)
+	log.Printf("- ExportAppDataContainer: %s", configs.ExportAppDataContainer)
+	log.Printf("- ExportFailureEvidence: %s", configs.ExportFailureEvidence)
+	log.Printf("- ReinstallApp: %s", configs.ReinstallApp)
+	log.Printf("- AdditionalAppPaths: %s", configs.AdditionalAppPaths)
+	log.Printf("- WarmUpApp: %s", configs.WarmUpApp)
+	log.Printf("- GrantPermissions: %s", configs.GrantPermissions)
+	log.Printf("- PushPayloads: %s", configs.PushPayloads)
+	log.Printf("- CreateSimulatorIfMissing: %s", configs.CreateSimulatorIfMissing)
+	log.Printf("- CleanupStaleSimulators: %s", configs.CleanupStaleSimulators)
+	log.Printf("- EraseSimulator: %s", configs.EraseSimulator)
+	log.Printf("- KeepSimulatorAlive: %s", configs.KeepSimulatorAlive)
+	log.Printf("- SimulatorBootAttempts: %s", configs.SimulatorBootAttempts)
+	log.Printf("- SimulatorBootRetryDelay: %s", configs.SimulatorBootRetryDelay)
+	log.Printf("- SnapshotSimulator: %s", configs.SnapshotSimulator)
+	log.Printf("- UseRAMDisk: %s", configs.UseRAMDisk)
+	log.Printf("- RAMDiskSizeMB: %s", configs.RAMDiskSizeMB)
+	log.Printf("- SimulatorLanguage: %s", configs.SimulatorLanguage)
+	log.Printf("- DisconnectHardwareKeyboard: %s", configs.DisconnectHardwareKeyboard)
+	log.Printf("- SimulatorLocale: %s", configs.SimulatorLocale)
+	log.Printf("- SimulatorTimezone: %s", configs.SimulatorTimezone)
+	log.Printf("- InterfaceStyle: %s", configs.InterfaceStyle)
+	log.Printf("- OverrideStatusBar: %s", configs.OverrideStatusBar)
+	log.Printf("- SimulatorLatitude: %s", configs.SimulatorLatitude)
+	log.Printf("- SimulatorLongitude: %s", configs.SimulatorLongitude)
 
 	log.Infof("Debug:")
 
 	log.Printf("- BuildTool: %s", configs.BuildTool)
+	log.Printf("- MonoPath: %s", configs.MonoPath)
+	log.Printf("- MonoVersion: %s", configs.MonoVersion)
+	log.Printf("- XcodeDeveloperDir: %s", configs.XcodeDeveloperDir)
+	log.Printf("- SimctlTimeout: %s", configs.SimctlTimeout)
 	log.Printf("- DeployDir: %s", configs.DeployDir)
+	log.Printf("- TestResultDir: %s", configs.TestResultDir)
 }
 
+// testEnvVarPattern matches a test_env_vars line in KEY=VALUE format.
+var testEnvVarPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
 func (configs ConfigsModel) validate() error {
-	if err := input.ValidateIfNotEmpty(configs.SimulatorDevice); err != nil {
-		return fmt.Errorf("SimulatorDevice - %s", err)
+	if configs.SimulatorUDID == "" {
+		if err := input.ValidateIfNotEmpty(configs.SimulatorDevice); err != nil {
+			return fmt.Errorf("SimulatorDevice - %s", err)
+		}
+		if err := input.ValidateIfNotEmpty(configs.SimulatorOsVersion); err != nil {
+			return fmt.Errorf("SimulatorOsVersion - %s", err)
+		}
+	}
+
+	if configs.TestFilter != "" {
+		if err := validateFilterExpression(configs.TestFilter); err != nil {
+			return fmt.Errorf("TestFilter - invalid expression: %s, error: %s, see: %s", configs.TestFilter, err, testSelectionDocsURL)
+		}
+	}
+
+	if configs.MinimumNunitVersion != "" {
+		if _, err := version.NewVersion(configs.MinimumNunitVersion); err != nil {
+			return fmt.Errorf("MinimumNunitVersion - invalid version: %s, error: %s", configs.MinimumNunitVersion, err)
+		}
+	}
+
+	if err := validateTestNames(configs.TestToRun); err != nil {
+		return fmt.Errorf("TestToRun - %s, see: %s", err, testSelectionDocsURL)
+	}
+
+	if configs.TestListPath != "" {
+		if err := input.ValidateIfPathExists(configs.TestListPath); err != nil {
+			return fmt.Errorf("TestListPath - %s", err)
+		}
+	}
+
+	if configs.BaselineResultPath != "" {
+		if err := input.ValidateIfPathExists(configs.BaselineResultPath); err != nil {
+			return fmt.Errorf("BaselineResultPath - %s", err)
+		}
+	}
+	if err := input.ValidateWithOptions(configs.FailOnNewFailuresOnly, "yes", "no"); err != nil {
+		return fmt.Errorf("FailOnNewFailuresOnly - %s", err)
+	}
+
+	if _, err := parseNonNegativeInt(configs.NunitWorkers); err != nil {
+		return fmt.Errorf("NunitWorkers - %s", err)
+	}
+
+	if err := input.ValidateWithOptions(configs.NunitLabels, nunitOptionDefault, "Off", "On", "OnOutputOnly", "Before", "After", "All"); err != nil {
+		return fmt.Errorf("NunitLabels - %s", err)
+	}
+
+	if _, err := parseNonNegativeInt(configs.TestTimeoutSeconds); err != nil {
+		return fmt.Errorf("TestTimeoutSeconds - %s", err)
+	}
+
+	if err := input.ValidateWithOptions(configs.StopOnFirstFailure, "yes", "no"); err != nil {
+		return fmt.Errorf("StopOnFirstFailure - %s", err)
+	}
+
+	for _, param := range testParams(configs.TestParams) {
+		if strings.Index(param, "=") <= 0 {
+			return fmt.Errorf("TestParams - invalid parameter: %s, should be in NAME=VALUE format", param)
+		}
+	}
+
+	if err := input.ValidateWithOptions(configs.NunitAgentArch, nunitOptionDefault, "x86"); err != nil {
+		return fmt.Errorf("NunitAgentArch - %s", err)
+	}
+
+	if err := input.ValidateWithOptions(configs.NunitProcess, nunitOptionDefault, "Single", "Separate", "Multiple"); err != nil {
+		return fmt.Errorf("NunitProcess - %s", err)
+	}
+
+	if err := input.ValidateWithOptions(configs.NunitDomain, nunitOptionDefault, "None", "Single", "Multiple"); err != nil {
+		return fmt.Errorf("NunitDomain - %s", err)
+	}
+
+	if configs.NunitConsolePath != "" {
+		if err := input.ValidateIfPathExists(configs.NunitConsolePath); err != nil {
+			return fmt.Errorf("NunitConsolePath - %s", err)
+		}
+	}
+
+	if err := input.ValidateWithOptions(configs.ExportJUnitResults, "yes", "no"); err != nil {
+		return fmt.Errorf("ExportJUnitResults - %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.ExportTRXResults, "yes", "no"); err != nil {
+		return fmt.Errorf("ExportTRXResults - %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.ExportFullResultsText, "yes", "no"); err != nil {
+		return fmt.Errorf("ExportFullResultsText - %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.ExportFailureEvidence, "yes", "no"); err != nil {
+		return fmt.Errorf("ExportFailureEvidence - %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.ListTestsOnly, "yes", "no"); err != nil {
+		return fmt.Errorf("ListTestsOnly - %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.CheckTestSelection, "yes", "no"); err != nil {
+		return fmt.Errorf("CheckTestSelection - %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.TestRunner, testRunnerNunitConsole, testRunnerDotnetTest, testRunnerAuto); err != nil {
+		return fmt.Errorf("TestRunner - %s", err)
+	}
+
+	if configs.XunitConsolePath != "" {
+		if err := input.ValidateIfPathExists(configs.XunitConsolePath); err != nil {
+			return fmt.Errorf("XunitConsolePath - %s", err)
+		}
+	}
+
+	if configs.Nunit2ConsolePath != "" {
+		if err := input.ValidateIfPathExists(configs.Nunit2ConsolePath); err != nil {
+			return fmt.Errorf("Nunit2ConsolePath - %s", err)
+		}
+	}
+
+	if err := input.ValidateWithOptions(configs.ResultFormat, resultFormatNunit3, resultFormatNunit2); err != nil {
+		return fmt.Errorf("ResultFormat - %s", err)
 	}
-	if err := input.ValidateIfNotEmpty(configs.SimulatorOsVersion); err != nil {
-		return fmt.Errorf("SimulatorOsVersion - %s", err)
+	if err := input.ValidateWithOptions(configs.TestOrder, testOrderDefault, testOrderShuffle); err != nil {
+		return fmt.Errorf("TestOrder - %s", err)
+	}
+	if configs.TestSeed != "" {
+		if _, err := strconv.ParseInt(configs.TestSeed, 10, 32); err != nil {
+			return fmt.Errorf("TestSeed - invalid value: %s, should be an integer", configs.TestSeed)
+		}
+	}
+
+	if _, err := splitArgs(configs.NunitExtraOptions); err != nil {
+		return fmt.Errorf("NunitExtraOptions - %s", err)
+	}
+
+	for _, env := range splitLines(configs.TestEnvVars) {
+		if !testEnvVarPattern.MatchString(env) {
+			return fmt.Errorf("TestEnvVars - invalid environment variable: %s, should be in KEY=VALUE format", env)
+		}
+	}
+
+	if err := input.ValidateWithOptions(configs.NunitTrace, nunitOptionDefault, "Off", "Error", "Warning", "Info", "Debug", "Verbose"); err != nil {
+		return fmt.Errorf("NunitTrace - %s", err)
+	}
+
+	if _, err := parseNonNegativeInt(configs.NunitAgents); err != nil {
+		return fmt.Errorf("NunitAgents - %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.CombineTestAssemblies, "yes", "no"); err != nil {
+		return fmt.Errorf("CombineTestAssemblies - %s", err)
+	}
+
+	if err := validateTestProjectFilter(configs.TestProjectFilter); err != nil {
+		return fmt.Errorf("TestProjectFilter - %s", err)
 	}
 
-	if err := input.ValidateIfPathExists(configs.XamarinSolution); err != nil {
+	if configs.MonoPath != "" {
+		if err := input.ValidateIfPathExists(configs.MonoPath); err != nil {
+			return fmt.Errorf("MonoPath - %s", err)
+		}
+	}
+
+	if err := input.ValidateIfNotEmpty(configs.XamarinSolution); err != nil {
 		return fmt.Errorf("XamarinSolution - %s", err)
 	}
 	if err := input.ValidateIfNotEmpty(configs.XamarinConfiguration); err != nil {
@@ -86,14 +492,170 @@ func (configs ConfigsModel) validate() error {
 	if err := input.ValidateIfNotEmpty(configs.XamarinPlatform); err != nil {
 		return fmt.Errorf("XamarinPlatform - %s", err)
 	}
+	if err := input.ValidateWithOptions(configs.NoTestProjectsBehavior, "fail", "warn", "skip"); err != nil {
+		return fmt.Errorf("NoTestProjectsBehavior - %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.NoTestsBehavior, "fail", "warn"); err != nil {
+		return fmt.Errorf("NoTestsBehavior - %s", err)
+	}
+	if _, err := parseNonNegativeInt(configs.MinFreeDiskSpaceGB); err != nil {
+		return fmt.Errorf("MinFreeDiskSpaceGB - %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.LowDiskSpaceBehavior, "fail", "warn"); err != nil {
+		return fmt.Errorf("LowDiskSpaceBehavior - %s", err)
+	}
+	if _, err := parseNonNegativeInt(configs.StackTraceLineLimit); err != nil {
+		return fmt.Errorf("StackTraceLineLimit - %s", err)
+	}
+	if _, err := parseNonNegativeInt(configs.ErrorMessageFailureLimit); err != nil {
+		return fmt.Errorf("ErrorMessageFailureLimit - %s", err)
+	}
+	if _, err := parseNonNegativeInt(configs.SlowTestThresholdSeconds); err != nil {
+		return fmt.Errorf("SlowTestThresholdSeconds - %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.ContinueOnFailure, "yes", "no"); err != nil {
+		return fmt.Errorf("ContinueOnFailure - %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.FailOnFlakyTests, "yes", "no"); err != nil {
+		return fmt.Errorf("FailOnFlakyTests - %s", err)
+	}
+	if _, err := parseNonNegativeInt(configs.RetryFailedTestsCount); err != nil {
+		return fmt.Errorf("RetryFailedTestsCount - %s", err)
+	}
+	if repeatCount, err := parseNonNegativeInt(configs.RepeatCount); err != nil {
+		return fmt.Errorf("RepeatCount - %s", err)
+	} else if repeatCount < 1 {
+		return fmt.Errorf("RepeatCount - should be at least 1")
+	}
+	if err := input.ValidateWithOptions(configs.RepeatStopOnFailure, "yes", "no"); err != nil {
+		return fmt.Errorf("RepeatStopOnFailure - %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.RecordVideo, "yes", "no"); err != nil {
+		return fmt.Errorf("RecordVideo - %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.CaptureSimulatorLog, "yes", "no"); err != nil {
+		return fmt.Errorf("CaptureSimulatorLog - %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.CollectSimulatorDiagnostics, "yes", "no"); err != nil {
+		return fmt.Errorf("CollectSimulatorDiagnostics - %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.ExportAppDataContainer, "yes", "no"); err != nil {
+		return fmt.Errorf("ExportAppDataContainer - %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.ReinstallApp, "yes", "no"); err != nil {
+		return fmt.Errorf("ReinstallApp - %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.WarmUpApp, "yes", "no"); err != nil {
+		return fmt.Errorf("WarmUpApp - %s", err)
+	}
+	for _, appPth := range splitList(configs.AdditionalAppPaths) {
+		if err := input.ValidateIfDirExists(appPth); err != nil {
+			return fmt.Errorf("AdditionalAppPaths - %s", err)
+		}
+	}
+	if err := input.ValidateWithOptions(configs.CreateSimulatorIfMissing, "yes", "no"); err != nil {
+		return fmt.Errorf("CreateSimulatorIfMissing - %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.CleanupStaleSimulators, "yes", "no"); err != nil {
+		return fmt.Errorf("CleanupStaleSimulators - %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.EraseSimulator, "yes", "no"); err != nil {
+		return fmt.Errorf("EraseSimulator - %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.KeepSimulatorAlive, "yes", "no"); err != nil {
+		return fmt.Errorf("KeepSimulatorAlive - %s", err)
+	}
+	if _, err := parseNonNegativeInt(configs.SimulatorBootAttempts); err != nil {
+		return fmt.Errorf("SimulatorBootAttempts - %s", err)
+	}
+	if _, err := parseNonNegativeInt(configs.SimulatorBootRetryDelay); err != nil {
+		return fmt.Errorf("SimulatorBootRetryDelay - %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.SnapshotSimulator, "yes", "no"); err != nil {
+		return fmt.Errorf("SnapshotSimulator - %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.UseRAMDisk, "yes", "no"); err != nil {
+		return fmt.Errorf("UseRAMDisk - %s", err)
+	}
+	if configs.UseRAMDisk == "yes" {
+		if sizeMB, err := parseNonNegativeInt(configs.RAMDiskSizeMB); err != nil {
+			return fmt.Errorf("RAMDiskSizeMB - %s", err)
+		} else if sizeMB == 0 {
+			return fmt.Errorf("RAMDiskSizeMB - invalid parameter: %s, should be greater than 0", configs.RAMDiskSizeMB)
+		}
+	}
+	if err := input.ValidateWithOptions(configs.DisconnectHardwareKeyboard, "yes", "no"); err != nil {
+		return fmt.Errorf("DisconnectHardwareKeyboard - %s", err)
+	}
+	for _, payloadPth := range splitList(configs.PushPayloads) {
+		if err := input.ValidateIfPathExists(payloadPth); err != nil {
+			return fmt.Errorf("PushPayloads - %s", err)
+		}
+	}
+	if configs.SimulatorTimezone != "" {
+		if _, err := time.LoadLocation(configs.SimulatorTimezone); err != nil {
+			return fmt.Errorf("SimulatorTimezone - invalid parameter: %s, error: %s", configs.SimulatorTimezone, err)
+		}
+	}
+	if err := input.ValidateWithOptions(configs.InterfaceStyle, interfaceStyleDefault, "light", "dark"); err != nil {
+		return fmt.Errorf("InterfaceStyle - %s", err)
+	}
+	if err := input.ValidateWithOptions(configs.OverrideStatusBar, "yes", "no"); err != nil {
+		return fmt.Errorf("OverrideStatusBar - %s", err)
+	}
+	if (configs.SimulatorLatitude == "") != (configs.SimulatorLongitude == "") {
+		return fmt.Errorf("SimulatorLatitude, SimulatorLongitude - both or none of them should be set")
+	}
+	if configs.SimulatorLatitude != "" {
+		if _, err := parseCoordinate(configs.SimulatorLatitude, 90); err != nil {
+			return fmt.Errorf("SimulatorLatitude - %s", err)
+		}
+		if _, err := parseCoordinate(configs.SimulatorLongitude, 180); err != nil {
+			return fmt.Errorf("SimulatorLongitude - %s", err)
+		}
+	}
 
-	if err := input.ValidateWithOptions(configs.BuildTool, "msbuild", "xbuild"); err != nil {
+	if err := input.ValidateWithOptions(configs.BuildTool, "msbuild", "xbuild", dotnetBuildTool); err != nil {
 		return fmt.Errorf("BuildTool - %s", err)
 	}
+	if _, err := parseNonNegativeInt(configs.SimctlTimeout); err != nil {
+		return fmt.Errorf("SimctlTimeout - %s", err)
+	}
+	if configs.XcodeDeveloperDir != "" {
+		if err := input.ValidateIfDirExists(configs.XcodeDeveloperDir); err != nil {
+			return fmt.Errorf("XcodeDeveloperDir - %s", err)
+		}
+	}
 
 	return nil
 }
 
+func parseNonNegativeInt(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid parameter: %s, should be a number", value)
+	}
+	if i < 0 {
+		return 0, fmt.Errorf("invalid parameter: %d, should not be negative", i)
+	}
+	return i, nil
+}
+
+func parseCoordinate(value string, limit float64) (float64, error) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid parameter: %s, should be a decimal number", value)
+	}
+	if f < -limit || f > limit {
+		return 0, fmt.Errorf("invalid parameter: %s, should be between %g and %g", value, -limit, limit)
+	}
+	return f, nil
+}
+
 func getLatestIOSVersion(osVersionSimulatorInfosMap simulator.OsVersionSimulatorInfosMap) (string, error) {
 	var latestVersionPtr *version.Version
 	for osVersion := range osVersionSimulatorInfosMap {
@@ -127,7 +689,7 @@ func getLatestIOSVersion(osVersionSimulatorInfosMap simulator.OsVersionSimulator
 }
 
 func getSimulatorInfo(osVersion, deviceName string) (simulator.InfoModel, error) {
-	osVersionSimulatorInfosMap, err := simulator.GetOsVersionSimulatorInfosMap()
+	osVersionSimulatorInfosMap, err := getOsVersionSimulatorInfosMap()
 	if err != nil {
 		return simulator.InfoModel{}, err
 	}
@@ -142,7 +704,16 @@ func getSimulatorInfo(osVersion, deviceName string) (simulator.InfoModel, error)
 
 	infos, ok := osVersionSimulatorInfosMap[osVersion]
 	if !ok {
-		return simulator.InfoModel{}, fmt.Errorf("No simulators found for os version: %s", osVersion)
+		return simulator.InfoModel{}, fmt.Errorf("No simulators found for os version: %s, available simulators:\n%s", osVersion, availableSimulators(osVersionSimulatorInfosMap))
+	}
+
+	if family, ok := latestDeviceFamilies[deviceName]; ok {
+		info, err := latestSimulatorInfo(infos, family)
+		if err != nil {
+			return simulator.InfoModel{}, fmt.Errorf("%s, os version: (%s), available simulators:\n%s", err, osVersion, availableSimulators(osVersionSimulatorInfosMap))
+		}
+		log.Printf("Device (%s) selected for: %s", info.Name, deviceName)
+		return info, nil
 	}
 
 	for _, info := range infos {
@@ -151,7 +722,99 @@ func getSimulatorInfo(osVersion, deviceName string) (simulator.InfoModel, error)
 		}
 	}
 
-	return simulator.InfoModel{}, fmt.Errorf("No simulators found for os version: (%s), device name: (%s)", osVersion, deviceName)
+	if info, ok := matchSimulatorInfo(infos, deviceName); ok {
+		log.Printf("Device (%s) selected for: %s", info.Name, deviceName)
+		return info, nil
+	}
+
+	return simulator.InfoModel{}, fmt.Errorf("No simulators found for os version: (%s), device name: (%s), available simulators:\n%s", osVersion, deviceName, availableSimulators(osVersionSimulatorInfosMap))
+}
+
+// availableSimulators lists the installed os versions, each followed by its device names.
+func availableSimulators(osVersionSimulatorInfosMap simulator.OsVersionSimulatorInfosMap) string {
+	osVersions := []string{}
+	for osVersion := range osVersionSimulatorInfosMap {
+		osVersions = append(osVersions, osVersion)
+	}
+	sort.Strings(osVersions)
+
+	lines := []string{}
+	for _, osVersion := range osVersions {
+		lines = append(lines, osVersion+":")
+
+		seen := map[string]bool{}
+		for _, info := range osVersionSimulatorInfosMap[osVersion] {
+			if info.Name == "" || seen[info.Name] {
+				continue
+			}
+			seen[info.Name] = true
+
+			lines = append(lines, "- "+info.Name)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// normalizedDeviceName returns the lowercased device name, with single spaces and without typographic quotes.
+func normalizedDeviceName(name string) string {
+	name = strings.NewReplacer("\u2018", "'", "\u2019", "'", "\u201c", "\"", "\u201d", "\"").Replace(name)
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// matchSimulatorInfo matches the device name case-insensitively; if there is no such device,
+// it picks the shortest device name starting with the given one (like `iPad Pro (9.7-inch)` for `iPad Pro`).
+func matchSimulatorInfo(infos []simulator.InfoModel, deviceName string) (simulator.InfoModel, bool) {
+	normalizedName := normalizedDeviceName(deviceName)
+
+	var match *simulator.InfoModel
+	for i, info := range infos {
+		normalizedInfoName := normalizedDeviceName(info.Name)
+		if normalizedInfoName == normalizedName {
+			return info, true
+		}
+
+		if strings.HasPrefix(normalizedInfoName, normalizedName+" ") || strings.HasPrefix(normalizedInfoName, normalizedName+"(") {
+			if match == nil || len(info.Name) < len(match.Name) {
+				match = &infos[i]
+			}
+		}
+	}
+
+	if match == nil {
+		return simulator.InfoModel{}, false
+	}
+	return *match, true
+}
+
+// exportTestResultLog exports the path of the test run's result xml and, if exportFullText is set, its content,
+// with the references to the screenshots and attachments pointing to the copies in the deploy dir.
+func exportTestResultLog(testRun TestRunModel, deployDir string, exportFullText bool) {
+	pth := testRun.ResultLogPth
+	if err := tools.ExportEnvironmentWithEnvman("BITRISE_XAMARIN_TEST_RESULT_PATH", pth); err != nil {
+		log.Warnf("Failed to export environment: %s, error: %s", "BITRISE_XAMARIN_TEST_RESULT_PATH", err)
+	}
+
+	if !exportFullText {
+		return
+	}
+
+	if resultLog, err := testResultLogContent(pth); err != nil {
+		log.Warnf("Failed to read test result, error: %s", err)
+	} else if resultLog != "" {
+		resultLog = maskSecrets(rewriteArtifactReferences(resultLog, testRun.DeployedFiles, deployDir))
+
+		// leave room for the key and the envman overhead
+		limit := envmanValueSizeLimit() - 1024
+		if truncated := truncateResultsText(resultLog, limit); len(truncated) < len(resultLog) {
+			log.Warnf("The test result (%d bytes) exceeds the environment variable size limit, exporting it truncated", len(resultLog))
+			resultLog = truncated
+		}
+
+		if err := tools.ExportEnvironmentWithEnvman("BITRISE_XAMARIN_TEST_FULL_RESULTS_TEXT", resultLog); err != nil {
+			log.Warnf("Failed to export environment: %s, error: %s", "BITRISE_XAMARIN_TEST_FULL_RESULTS_TEXT", err)
+		}
+	}
 }
 
 func testResultLogContent(pth string) (string, error) {
@@ -161,46 +824,229 @@ func testResultLogContent(pth string) (string, error) {
 		return "", fmt.Errorf("test result not exist at: %s", pth)
 	}
 
-	content, err := fileutil.ReadStringFromFile(pth)
+	content, err := readTestResultContent(pth)
 	if err != nil {
-		return "", fmt.Errorf("Failed to read file (%s), error: %s", pth, err)
+		return "", err
+	}
+
+	return strings.ToValidUTF8(string(content), "\uFFFD"), nil
+}
+
+func xamarinUITestProjectNames(solutionPth string) ([]string, error) {
+	sln, err := solution.New(solutionPth, true)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to analyze solution (%s), error: %s", solutionPth, err)
+	}
+
+	projectNames := []string{}
+	for _, proj := range sln.ProjectMap {
+		if proj.TestFramework == constants.TestFrameworkXamarinUITest {
+			projectNames = append(projectNames, proj.Name)
+		}
+	}
+
+	return projectNames, nil
+}
+
+// envKeys returns the keys of the KEY=VALUE environment variables, to print them without their (possibly secret) values.
+func envKeys(envs []string) []string {
+	keys := []string{}
+	for _, env := range envs {
+		keys = append(keys, strings.SplitN(env, "=", 2)[0])
+	}
+	return keys
+}
+
+// monoFrameworkVersionsDir is the dir of the Mono versions installed by the Mono framework package.
+const monoFrameworkVersionsDir = "/Library/Frameworks/Mono.framework/Versions"
+
+// resolveMonoPath returns the mono to run the test runners with: the mono_path, the mono of the mono_version,
+// or the Current Mono framework version.
+func resolveMonoPath(monoPath, monoVersion string) (string, error) {
+	if monoPath != "" {
+		return monoPath, nil
+	}
+	if monoVersion == "" {
+		return constants.MonoPath, nil
+	}
+
+	pth := filepath.Join(monoFrameworkVersionsDir, monoVersion, "Commands", "mono")
+	if exist, err := pathutil.IsPathExists(pth); err != nil {
+		return "", fmt.Errorf("Failed to check if file (%s) exist, error: %s", pth, err)
+	} else if !exist {
+		versions := []string{}
+		if infos, err := ioutil.ReadDir(monoFrameworkVersionsDir); err == nil {
+			for _, info := range infos {
+				versions = append(versions, info.Name())
+			}
+		}
+		return "", fmt.Errorf("Mono version (%s) not installed, available versions: %s", monoVersion, strings.Join(versions, ", "))
 	}
+	return pth, nil
+}
 
-	return content, nil
+func splitLines(lines string) []string {
+	items := []string{}
+	for _, item := range strings.Split(lines, "\n") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
 
-func parseErrorFromResultLog(content string) (string, error) {
-	failureLineFound := false
-	lastFailureMessage := ""
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
 
-	scanner := bufio.NewScanner(strings.NewReader(content))
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+func uniqueStrings(items []string) []string {
+	unique := []string{}
+	seen := map[string]bool{}
+	for _, item := range items {
+		if !seen[item] {
+			seen[item] = true
+			unique = append(unique, item)
+		}
+	}
+	return unique
+}
 
-		if line == "<failure>" {
-			failureLineFound = true
+// excludedCategories returns the categories to skip:
+// the exclude_categories and the device_only_categories, as the tests always run on simulators.
+func excludedCategories(configs ConfigsModel) []string {
+	return uniqueStrings(append(splitList(configs.ExcludeCategories), splitList(configs.DeviceOnlyCategories)...))
+}
+
+func filterTestProjectOutputMap(testProjectOutputMap builder.TestProjectOutputMap, projectNames []string) (builder.TestProjectOutputMap, []string) {
+	filtered := builder.TestProjectOutputMap{}
+	warnings := []string{}
+
+	for _, projectName := range projectNames {
+		testProjectOutput, ok := testProjectOutputMap[projectName]
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("Test project (%s) set in test_projects_to_run has no test output", projectName))
 			continue
 		}
+		filtered[projectName] = testProjectOutput
+	}
+
+	return filtered, warnings
+}
+
+// testProjectFilterMatches returns true if the test project name matches the test_project_filter:
+// a regexp between slashes (/Smoke$/) or a comma-separated list of glob patterns (*Smoke*, *Login*).
+func testProjectFilterMatches(filter, projectName string) bool {
+	if len(filter) > 1 && strings.HasPrefix(filter, "/") && strings.HasSuffix(filter, "/") {
+		exp, err := regexp.Compile(filter[1 : len(filter)-1])
+		return err == nil && exp.MatchString(projectName)
+	}
 
-		if failureLineFound && strings.HasPrefix(line, "<message>") {
-			lastFailureMessage = line
+	for _, pattern := range splitList(filter) {
+		if match, err := filepath.Match(pattern, projectName); err == nil && match {
+			return true
 		}
+	}
+	return false
+}
 
-		failureLineFound = false
+func validateTestProjectFilter(filter string) error {
+	if len(filter) > 1 && strings.HasPrefix(filter, "/") && strings.HasSuffix(filter, "/") {
+		_, err := regexp.Compile(filter[1 : len(filter)-1])
+		return err
 	}
 
-	return lastFailureMessage, nil
+	for _, pattern := range splitList(filter) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern: %s, error: %s", pattern, err)
+		}
+	}
+	return nil
+}
+
+func filterTestProjectOutputMapByPattern(testProjectOutputMap builder.TestProjectOutputMap, filter string) builder.TestProjectOutputMap {
+	filtered := builder.TestProjectOutputMap{}
+	for projectName, testProjectOutput := range testProjectOutputMap {
+		if testProjectFilterMatches(filter, projectName) {
+			filtered[projectName] = testProjectOutput
+		} else {
+			log.Printf("Test project (%s) does not match test_project_filter, skipping...", projectName)
+		}
+	}
+	return filtered
+}
+
+// skipTests finishes the step successfully without running tests, exporting the skipped result.
+func skipTests(reason string) {
+	fmt.Println()
+	log.Warnf("%s, skipping tests...", reason)
+	if err := tools.ExportEnvironmentWithEnvman("BITRISE_XAMARIN_TEST_RESULT", "skipped"); err != nil {
+		log.Warnf("Failed to export environment: %s, error: %s", "BITRISE_XAMARIN_TEST_RESULT", err)
+	}
+	cleanup()
+	os.Exit(0)
+}
+
+// handleNoTestProjects finishes the step according to the no_test_projects_behavior input,
+// when there is nothing to test in the solution.
+func handleNoTestProjects(behavior, message string) {
+	switch behavior {
+	case "fail":
+		failf("%s", message)
+	case "skip":
+		skipTests(message)
+	default:
+		fmt.Println()
+		log.Warnf("%s", message)
+		log.Warnf("No Xamarin.UITest was run, make sure the solution contains Xamarin.UITest projects referring to an iOS app project.")
+		log.Warnf("Set no_test_projects_behavior to 'fail' to fail the step in this case.")
+	}
+
+	if err := tools.ExportEnvironmentWithEnvman("BITRISE_XAMARIN_TEST_RESULT", "succeeded"); err != nil {
+		log.Warnf("Failed to export environment: %s, error: %s", "BITRISE_XAMARIN_TEST_RESULT", err)
+	}
+	cleanup()
+	os.Exit(0)
+}
+
+var cleanupFuncs []func()
+
+// registerCleanup registers a function to run before the step exits.
+func registerCleanup(fn func()) {
+	cleanupFuncs = append(cleanupFuncs, fn)
+}
+
+func cleanup() {
+	for i := len(cleanupFuncs) - 1; i >= 0; i-- {
+		cleanupFuncs[i]()
+	}
+	cleanupFuncs = nil
 }
 
 func failf(format string, v ...interface{}) {
+	failWithResult("failed", format, v...)
+}
+
+// failWithResult fails the step with the given BITRISE_XAMARIN_TEST_RESULT, failed or partially_failed.
+func failWithResult(result, format string, v ...interface{}) {
 	log.Errorf(format, v...)
-	if err := tools.ExportEnvironmentWithEnvman("BITRISE_XAMARIN_TEST_RESULT", "failed"); err != nil {
+	cleanup()
+	if err := tools.ExportEnvironmentWithEnvman("BITRISE_XAMARIN_TEST_RESULT", result); err != nil {
 		log.Warnf("Failed to export environment: %s, error: %s", "BITRISE_XAMARIN_TEST_RESULT", err)
 	}
 	os.Exit(1)
 }
 
 func main() {
+	defer cleanup()
+
 	configs := createConfigsModelFromEnvs()
 
 	fmt.Println()
@@ -210,25 +1056,102 @@ func main() {
 		failf("Issue with input: %s", err)
 	}
 
-	// Get Simulator Infos
-	fmt.Println()
-	log.Infof("Collecting simulator info...")
-	simulatorInfo, err := getSimulatorInfo(configs.SimulatorOsVersion, configs.SimulatorDevice)
-	if err != nil {
-		failf("Failed to get simulator infos, error: %s", err)
+	simctlTimeoutSec, _ := parseNonNegativeInt(configs.SimctlTimeout)
+	simctlTimeout = time.Duration(simctlTimeoutSec) * time.Second
+
+	secretValues = secretEnvValues(configs.SecretEnvKeys, splitLines(configs.TestEnvVars))
+
+	// DEVELOPER_DIR is inherited by every child process (simctl, msbuild, nunit),
+	// so the whole step uses the same Xcode
+	if configs.XcodeDeveloperDir != "" {
+		if err := os.Setenv("DEVELOPER_DIR", configs.XcodeDeveloperDir); err != nil {
+			failf("Failed to set DEVELOPER_DIR environment, error: %s", err)
+		}
 	}
-	log.Donef("Simulator (%s), id: (%s), status: %s", simulatorInfo.Name, simulatorInfo.ID, simulatorInfo.Status)
 
-	if err := os.Setenv("IOS_SIMULATOR_UDID", simulatorInfo.ID); err != nil {
-		failf("Failed to export simulator UDID, error: %s", err)
+	// Resolve solution
+	testProjectPth := ""
+	solutionPth := configs.XamarinSolution
+	var err error
+	if isProjectPath(configs.XamarinSolution) {
+		testProjectPth = configs.XamarinSolution
+		solutionPth, err = findSolutionForProject(testProjectPth)
+		if err != nil {
+			failf("Failed to find solution for project (%s), error: %s", testProjectPth, err)
+		}
+	} else {
+		solutionPth, err = resolveSolutionPath(configs.XamarinSolution)
+		if err != nil {
+			failf("Failed to resolve solution, error: %s", err)
+		}
+	}
+	if solutionPth != configs.XamarinSolution {
+		log.Donef("Using solution: %s", solutionPth)
 	}
+	if err := input.ValidateIfPathExists(solutionPth); err != nil {
+		failf("Issue with input: XamarinSolution - %s", err)
+	}
+	configs.XamarinSolution = solutionPth
+	// ---
 
+	// Check free disk space
+	if minFreeDiskSpaceGB, _ := parseNonNegativeInt(configs.MinFreeDiskSpaceGB); minFreeDiskSpaceGB > 0 {
+		fmt.Println()
+		log.Infof("Checking free disk space...")
+		messages, err := checkFreeDiskSpace([]string{pathutil.UserHomeDir(), configs.DeployDir, filepath.Dir(solutionPth), os.TempDir()}, minFreeDiskSpaceGB)
+		if err != nil {
+			log.Warnf("Failed to check free disk space, error: %s", err)
+		}
+		for _, message := range messages {
+			if configs.LowDiskSpaceBehavior == "fail" {
+				failf("%s", message)
+			}
+			log.Warnf("%s", message)
+		}
+	}
 	// ---
 
-	// Nunit Console path
-	nunitConsolePth, err := nunit.SystemNunit3ConsolePath()
+	// Check for Xamarin.UITest projects
+	testProjectNames, err := xamarinUITestProjectNames(configs.XamarinSolution)
 	if err != nil {
-		failf("Failed to get system insatlled nunit3-console.exe path, error: %s", err)
+		failf("Failed to collect Xamarin.UITest projects, error: %s", err)
+	}
+	if len(testProjectNames) == 0 {
+		handleNoTestProjects(configs.NoTestProjectsBehavior, fmt.Sprintf("No Xamarin.UITest project found in solution: %s", configs.XamarinSolution))
+	}
+	// ---
+
+	if configs.CleanupStaleSimulators == "yes" {
+		fmt.Println()
+		log.Infof("Cleaning up stale simulator processes...")
+		killed, err := cleanupStaleSimulators()
+		if err != nil {
+			failf("Failed to clean up stale simulator processes, error: %s", err)
+		}
+		for _, process := range killed {
+			log.Printf("killed: %s", process)
+		}
+		log.Donef("%d stale simulator process(es) killed", len(killed))
+	}
+
+	// Get Simulator Infos
+	fmt.Println()
+	log.Infof("Collecting simulator info...")
+	var simulators []SimulatorModel
+	if configs.SimulatorUDID != "" {
+		sim, err := getSimulatorByUDID(configs.SimulatorUDID)
+		if err != nil {
+			failf("Failed to get simulator info, error: %s", err)
+		}
+		simulators = []SimulatorModel{sim}
+	} else {
+		simulators, err = getSimulators(configs.SimulatorDevice, configs.SimulatorOsVersion, configs.CreateSimulatorIfMissing == "yes")
+		if err != nil {
+			failf("Failed to get simulator infos, error: %s", err)
+		}
+	}
+	for _, sim := range simulators {
+		log.Donef("Simulator (%s), id: (%s), status: %s", sim.Info.Name, sim.Info.ID, sim.Info.Status)
 	}
 	// ---
 
@@ -265,7 +1188,14 @@ func main() {
 	}
 
 	startTime := time.Now()
-	warnings, err := builder.BuildAndRunAllXamarinUITestAndReferredProjects(configs.XamarinConfiguration, configs.XamarinPlatform, nil, callback)
+	var warnings []string
+	if configs.BuildTool == dotnetBuildTool {
+		warnings, err = buildMixedSolution(configs.XamarinSolution, testProjectPth, configs.XamarinConfiguration, configs.XamarinPlatform, buildTool, callback)
+	} else if testProjectPth != "" {
+		warnings, err = buildXamarinUITestProject(configs.XamarinSolution, testProjectPth, configs.XamarinConfiguration, configs.XamarinPlatform, buildTool, callback)
+	} else {
+		warnings, err = builder.BuildAndRunAllXamarinUITestAndReferredProjects(configs.XamarinConfiguration, configs.XamarinPlatform, nil, callback)
+	}
 	endTime := time.Now()
 
 	for _, warning := range warnings {
@@ -290,96 +1220,423 @@ func main() {
 	if len(testProjectOutputMap) == 0 {
 		failf("No testable output generated")
 	}
+
+	if testProjectPth != "" {
+		testProjectName, err := projectNameInSolution(configs.XamarinSolution, testProjectPth)
+		if err != nil {
+			failf("Failed to determine test project name, error: %s", err)
+		}
+
+		testProjectOutputMap, warnings = filterTestProjectOutputMap(testProjectOutputMap, []string{testProjectName})
+		for _, warning := range warnings {
+			log.Warnf(warning)
+		}
+		if len(testProjectOutputMap) == 0 {
+			failf("No testable output generated for test project: %s", testProjectName)
+		}
+	}
+
+	if testProjectsToRun := splitList(configs.TestProjectsToRun); len(testProjectsToRun) > 0 {
+		testProjectOutputMap, warnings = filterTestProjectOutputMap(testProjectOutputMap, testProjectsToRun)
+		for _, warning := range warnings {
+			log.Warnf(warning)
+		}
+
+		if len(testProjectOutputMap) == 0 {
+			skipTests(fmt.Sprintf("test_projects_to_run (%s) excludes every test project", configs.TestProjectsToRun))
+		}
+	}
+
+	if configs.TestProjectFilter != "" {
+		testProjectOutputMap = filterTestProjectOutputMapByPattern(testProjectOutputMap, configs.TestProjectFilter)
+		if len(testProjectOutputMap) == 0 {
+			skipTests(fmt.Sprintf("test_project_filter (%s) matches no test project", configs.TestProjectFilter))
+		}
+	}
+	// ---
+
+	// Check app architectures
+	fmt.Println()
+	hostArch := hostArchitecture()
+	log.Infof("Checking app architectures (host: %s)...", hostArch)
+	appPths := []string{}
+	checkedAppPths := map[string]bool{}
+	for _, testProjectOutput := range testProjectOutputMap {
+		for _, projectName := range testProjectOutput.ReferredProjectNames {
+			appPth := projectAppPath(projectOutputMap[projectName])
+			if appPth == "" || checkedAppPths[appPth] {
+				continue
+			}
+			checkedAppPths[appPth] = true
+
+			if err := checkAppArchitecture(appPth, hostArch); err != nil {
+				failf("Failed to check app architecture, error: %s", err)
+			}
+			appPths = append(appPths, appPth)
+		}
+	}
+	// ---
+
+	// Nunit Console path
+	nunitConsolePth, err := resolveNunitConsolePath(configs.NunitConsolePath, configs.XamarinSolution)
+	if err != nil {
+		failf("Failed to get nunit3-console.exe path, error: %s", err)
+	}
+	log.Printf("nunit3-console: %s", nunitConsolePth)
 	// ---
 
 	//
 	// Run nunit tests
-	nunitConsole, err := nunit.New(nunitConsolePth)
+	absNunitConsolePth, err := pathutil.AbsPath(nunitConsolePth)
 	if err != nil {
-		failf("Failed to create nunit console model, error: %s", err)
+		failf("Failed to expand path (%s), error: %s", nunitConsolePth, err)
 	}
 
-	resultLogPth := filepath.Join(configs.DeployDir, "TestResult.xml")
-	nunitConsole.SetResultLogPth(resultLogPth)
+	monoPth, err := resolveMonoPath(configs.MonoPath, configs.MonoVersion)
+	if err != nil {
+		failf("Failed to find mono, error: %s", err)
+	}
+	configs.MonoPath = monoPth
+	if monoVersion, err := command.New(monoPth, "--version").RunAndReturnTrimmedCombinedOutput(); err != nil {
+		log.Warnf("Failed to get mono version, error: %s", err)
+	} else {
+		log.Printf("mono: %s (%s)", monoPth, strings.SplitN(monoVersion, "\n", 2)[0])
+	}
 
-	// Artifacts
-	resultLog := ""
+	if configs.TestRunner != testRunnerDotnetTest {
+		consoleVersion, err := assemblyVersion(monoPth, absNunitConsolePth)
+		if err != nil {
+			if configs.MinimumNunitVersion != "" {
+				failf("Failed to get nunit3-console version, error: %s", err)
+			}
+			log.Warnf("Failed to get nunit3-console version, error: %s", err)
+		} else {
+			log.Printf("nunit3-console version: %s", consoleVersion)
 
-	for testProjectName, testProjectOutput := range testProjectOutputMap {
-		if len(testProjectOutput.ReferredProjectNames) == 0 {
-			log.Warnf("Test project (%s) does not refers to any project, skipping...", testProjectName)
+			if configs.MinimumNunitVersion != "" {
+				if err := checkNunitConsoleVersion(consoleVersion, configs.MinimumNunitVersion); err != nil {
+					failf("%s", err)
+				}
+			}
+		}
+	}
+
+	if configs.NunitWorkDir != "" {
+		absNunitWorkDir, err := pathutil.AbsPath(configs.NunitWorkDir)
+		if err != nil {
+			failf("Failed to expand path (%s), error: %s", configs.NunitWorkDir, err)
+		}
+		if err := pathutil.EnsureDirExist(absNunitWorkDir); err != nil {
+			failf("Failed to create dir (%s), error: %s", absNunitWorkDir, err)
+		}
+		configs.NunitWorkDir = absNunitWorkDir
+
+		// the relative paths of the runner are relative to the work dir
+		absDeployDir, err := pathutil.AbsPath(configs.DeployDir)
+		if err != nil {
+			failf("Failed to expand path (%s), error: %s", configs.DeployDir, err)
+		}
+		configs.DeployDir = absDeployDir
+	}
+
+	if configs.TestOrder == testOrderShuffle && configs.TestSeed == "" {
+		configs.TestSeed = strconv.Itoa(rand.New(rand.NewSource(time.Now().UnixNano())).Intn(math.MaxInt32))
+		log.Printf("test_seed: %s (set test_seed to it to reproduce the test order)", configs.TestSeed)
+	}
+
+	runners := &TestRunnersModel{
+		configs: configs,
+		nunit3: nunit3Runner{
+			monoPth:      configs.MonoPath,
+			consolePth:   absNunitConsolePth,
+			options:      nunitOptions(configs),
+			resultFormat: configs.ResultFormat,
+			testListPth:  configs.TestListPath,
+			envs:         splitLines(configs.TestEnvVars),
+		},
+	}
+	if configs.TestRunner == testRunnerAuto {
+		sdkStyleTestProjects, err := sdkStyleTestProjectNames(configs.XamarinSolution)
+		if err != nil {
+			failf("Failed to find the SDK-style test projects, error: %s", err)
+		}
+		runners.sdkStyleTestProjects = sdkStyleTestProjects
+	}
+
+	if configs.ListTestsOnly == "yes" {
+		listTests(configs, runners, testProjectOutputMap)
+		return
+	}
+
+	if configs.CheckTestSelection == "yes" {
+		checkTestSelection(configs, runners, testProjectOutputMap)
+	}
+
+	repeatCount, _ := parseNonNegativeInt(configs.RepeatCount)
+
+	testRuns := []TestRunModel{}
+	for _, sim := range simulators {
+		resultLogSuffix := ""
+		if len(simulators) > 1 {
+			fmt.Println()
+			log.Infof("Running tests on simulator: %s", sim.Name())
+
+			resultLogSuffix = "-" + sanitizedFileName(sim.Name())
+		}
+
+		prepareSimulator(configs, &sim, appPths)
+
+		if repeatCount == 1 {
+			testRuns = append(testRuns, runTestPass(configs, runners, sim, 0, resultLogSuffix, testProjectOutputMap, projectOutputMap)...)
 			continue
 		}
 
-		for _, projectName := range testProjectOutput.ReferredProjectNames {
-			projectOutput, ok := projectOutputMap[projectName]
-			if !ok {
-				continue
+		for iteration := 1; iteration <= repeatCount; iteration++ {
+			fmt.Println()
+			log.Infof("Repeated test run %d/%d", iteration, repeatCount)
+
+			if iteration > 1 && sim.SnapshotDir != "" {
+				restoreSimulator(configs, &sim)
+			}
+
+			passRuns := runTestPass(configs, runners, sim, iteration, fmt.Sprintf("%s-run-%d", resultLogSuffix, iteration), testProjectOutputMap, projectOutputMap)
+			testRuns = append(testRuns, passRuns...)
+
+			if configs.RepeatStopOnFailure == "yes" && len(passRuns) > 0 && passRuns[len(passRuns)-1].Err != nil {
+				log.Errorf("Test run %d/%d failed, skipping the remaining repeated test runs", iteration, repeatCount)
+				break
 			}
+		}
+	}
+
+	if len(testRuns) == 0 {
+		handleNoTestProjects(configs.NoTestProjectsBehavior, "No Xamarin.UITest project was tested against an app")
+	}
+
+	flakyTests := []string{}
+	var failedRun *TestRunModel
+	for i, testRun := range testRuns {
+		flakyTests = append(flakyTests, testRun.FlakyTests...)
+		if testRun.Err != nil && failedRun == nil {
+			failedRun = &testRuns[i]
+		}
+	}
 
-			appPth := ""
-			for _, output := range projectOutput.Outputs {
-				if output.OutputType == constants.OutputTypeAPP {
-					appPth = output.Pth
+	fmt.Println()
+	log.Infof("Test run summary:")
+	for _, testRun := range testRuns {
+		status := "succeeded"
+		if testRun.Err != nil {
+			status = "failed"
+		}
+		if testRun.Results != nil {
+			status += fmt.Sprintf(" (%d passed, %d failed, %d skipped)", testRun.Results.Passed, testRun.Results.Failed, testRun.Results.Skipped)
+		}
+
+		if testRun.Err != nil {
+			log.Errorf("- %s: %s", testRun.FullName(), status)
+		} else {
+			log.Donef("- %s: %s", testRun.FullName(), status)
+		}
+		log.Printf("  %s", testRun.ResultLogPth)
+	}
+
+	if len(simulators) > 1 {
+		fmt.Println()
+		log.Infof("Simulator summary:")
+		for _, sim := range simulators {
+			status := "succeeded"
+			for _, testRun := range testRuns {
+				if testRun.Simulator.Info.ID == sim.Info.ID && testRun.Err != nil {
+					status = "failed"
 				}
 			}
 
-			if appPth == "" {
-				failf("No app generated for project: %s", projectName)
+			if status == "failed" {
+				log.Errorf("- %s: %s", sim.Name(), status)
+			} else {
+				log.Donef("- %s: %s", sim.Name(), status)
 			}
+		}
+	}
 
-			// Set APP_BUNDLE_PATH env to let the test know which .app file should be tested
-			// This env is used in the Xamarin.UITest project to refer to the .app path
-			if err := os.Setenv("APP_BUNDLE_PATH", appPth); err != nil {
-				failf("Failed to set APP_BUNDLE_PATH environment, without this env test will fail, error: %s", err)
+	statuses := osVersionStatuses(simulators, testRuns)
+	if len(statuses) > 1 {
+		fmt.Println()
+		log.Infof("OS version summary:")
+		for _, status := range statuses {
+			if strings.HasSuffix(status, "failed") {
+				log.Errorf("- %s", status)
+			} else {
+				log.Donef("- %s", status)
 			}
+		}
+	}
+	if err := tools.ExportEnvironmentWithEnvman("BITRISE_XAMARIN_TEST_OS_VERSION_RESULTS", strings.Join(statuses, "\n")); err != nil {
+		log.Warnf("Failed to export environment: %s, error: %s", "BITRISE_XAMARIN_TEST_OS_VERSION_RESULTS", err)
+	}
 
-			// Run test
+	if repeatCount > 1 {
+		if unstable := unstableTests(testRuns); len(unstable) > 0 {
 			fmt.Println()
-			log.Infof("Testing (%s) against (%s)", testProjectName, projectName)
-			log.Printf("test dll: %s", testProjectOutput.Output.Pth)
-			log.Printf("app: %s", appPth)
+			log.Warnf("Tests failing only in some of the repeated test runs:")
+			for _, test := range unstable {
+				log.Warnf("- %s", test)
+			}
+		}
+	}
 
-			nunitConsole.SetDLLPth(testProjectOutput.Output.Pth)
-			nunitConsole.SetTestToRun(configs.TestToRun)
+	if len(flakyTests) > 0 {
+		fmt.Println()
+		log.Warnf("Flaky tests (failed, then passed on retry):")
+		for _, name := range flakyTests {
+			log.Warnf("- %s", name)
+		}
+	}
+	if err := tools.ExportEnvironmentWithEnvman("BITRISE_XAMARIN_FLAKY_TESTS", strings.Join(uniqueStrings(flakyTests), "\n")); err != nil {
+		log.Warnf("Failed to export environment: %s, error: %s", "BITRISE_XAMARIN_FLAKY_TESTS", err)
+	}
 
+	if slowTestThreshold, _ := parseNonNegativeInt(configs.SlowTestThresholdSeconds); slowTestThreshold > 0 {
+		if slow := slowTests(testRuns, float64(slowTestThreshold)); len(slow) > 0 {
 			fmt.Println()
-			log.Infof("Running Xamarin UITest")
-			log.Donef("$ %s", nunitConsole.PrintableCommand())
-			fmt.Println()
+			log.Warnf("Slow tests (running longer than %ds):", slowTestThreshold)
+			for _, test := range slow {
+				log.Warnf("- %s", test)
+			}
+		}
+	}
 
-			err := nunitConsole.Run()
-			testLog, readErr := testResultLogContent(resultLogPth)
-			if readErr != nil {
-				log.Warnf("Failed to read test result, error: %s", readErr)
+	counts := testCounts(testRuns)
+	for _, env := range []struct {
+		key   string
+		count int
+	}{
+		{"BITRISE_XAMARIN_TEST_TOTAL_COUNT", counts.Total},
+		{"BITRISE_XAMARIN_TEST_PASSED_COUNT", counts.Passed},
+		{"BITRISE_XAMARIN_TEST_FAILED_COUNT", counts.Failed},
+		{"BITRISE_XAMARIN_TEST_SKIPPED_COUNT", counts.Skipped},
+	} {
+		if err := tools.ExportEnvironmentWithEnvman(env.key, strconv.Itoa(env.count)); err != nil {
+			log.Warnf("Failed to export environment: %s, error: %s", env.key, err)
+		}
+	}
+
+	testProjectNames, testProjectStatuses := testProjectStatuses(testRuns)
+	for _, name := range testProjectNames {
+		key := testProjectResultEnvKey(name)
+		if err := tools.ExportEnvironmentWithEnvman(key, testProjectStatuses[name]); err != nil {
+			log.Warnf("Failed to export environment: %s, error: %s", key, err)
+		}
+	}
+
+	if err := tools.ExportEnvironmentWithEnvman("BITRISE_XAMARIN_FAILED_TESTS", strings.Join(uniqueFailedTestNames(testRuns), "\n")); err != nil {
+		log.Warnf("Failed to export environment: %s, error: %s", "BITRISE_XAMARIN_FAILED_TESTS", err)
+	}
+
+	errorMessageFailureLimit, _ := parseNonNegativeInt(configs.ErrorMessageFailureLimit)
+	if err := tools.ExportEnvironmentWithEnvman("BITRISE_XAMARIN_TEST_ERROR_MESSAGE", maskSecrets(errorMessageDigest(testRuns, errorMessageFailureLimit))); err != nil {
+		log.Warnf("Failed to export environment: %s, error: %s", "BITRISE_XAMARIN_TEST_ERROR_MESSAGE", err)
+	}
+
+	if err := tools.ExportEnvironmentWithEnvman("BITRISE_XAMARIN_TEST_SUMMARY_MD", maskSecrets(markdownSummary(testRuns, configs.DeployDir))); err != nil {
+		log.Warnf("Failed to export environment: %s, error: %s", "BITRISE_XAMARIN_TEST_SUMMARY_MD", err)
+	}
+
+	mergedResultPth := filepath.Join(configs.DeployDir, "TestResult.xml")
+	if err := writeMergedTestResults(mergedResultPth, configs.DeployDir, testRuns); err != nil {
+		log.Warnf("Failed to merge test results, error: %s", err)
+	} else if err := tools.ExportEnvironmentWithEnvman("BITRISE_XAMARIN_TEST_MERGED_RESULT_PATH", mergedResultPth); err != nil {
+		log.Warnf("Failed to export environment: %s, error: %s", "BITRISE_XAMARIN_TEST_MERGED_RESULT_PATH", err)
+	}
+
+	timingPth := filepath.Join(configs.DeployDir, "tests-timing.csv")
+	if err := writeTimingCSV(timingPth, testRuns); err != nil {
+		log.Warnf("Failed to write test timings, error: %s", err)
+	} else if err := tools.ExportEnvironmentWithEnvman("BITRISE_XAMARIN_TEST_TIMING_PATH", timingPth); err != nil {
+		log.Warnf("Failed to export environment: %s, error: %s", "BITRISE_XAMARIN_TEST_TIMING_PATH", err)
+	}
+
+	summaryPth := filepath.Join(configs.DeployDir, "results-summary.json")
+	if err := writeJSONSummary(summaryPth, testRuns); err != nil {
+		log.Warnf("Failed to write test summary, error: %s", err)
+	} else if err := tools.ExportEnvironmentWithEnvman("BITRISE_XAMARIN_TEST_SUMMARY_PATH", summaryPth); err != nil {
+		log.Warnf("Failed to export environment: %s, error: %s", "BITRISE_XAMARIN_TEST_SUMMARY_PATH", err)
+	}
+
+	fmt.Println()
+	log.Infof("Test results:")
+	fmt.Print(summaryTable(testRuns))
+
+	if failedRun != nil {
+		stackTraceLineLimit, _ := parseNonNegativeInt(configs.StackTraceLineLimit)
+		failedTestCount := 0
+		for _, testRun := range testRuns {
+			failed := failedTestCases(testRun)
+			if len(failed) == 0 {
+				continue
 			}
-			resultLog = testLog
+			failedTestCount += len(failed)
 
-			if err != nil {
-				if errorMsg, err := parseErrorFromResultLog(resultLog); err != nil {
-					log.Warnf("Failed to parse error message from result log, error: %s", err)
-				} else if errorMsg != "" {
-					log.Errorf("%s", errorMsg)
-				}
+			fmt.Println()
+			log.Errorf("Failed tests of %s:", testRun.FullName())
+			logFailedTestCases(failed, stackTraceLineLimit)
+		}
+		if failedTestCount > 0 {
+			fmt.Println()
+			log.Errorf("%d test(s) failed", failedTestCount)
+		}
+	}
 
-				if resultLog != "" {
-					if err := tools.ExportEnvironmentWithEnvman("BITRISE_XAMARIN_TEST_FULL_RESULTS_TEXT", resultLog); err != nil {
-						log.Warnf("Failed to export environment: %s, error: %s", "BITRISE_XAMARIN_TEST_FULL_RESULTS_TEXT", err)
-					}
+	if configs.BaselineResultPath != "" {
+		newFailures, knownFailures, err := compareWithBaseline(configs.BaselineResultPath, uniqueFailedTestNames(testRuns))
+		if err != nil {
+			log.Warnf("Failed to compare the test results with the baseline, error: %s", err)
+		} else {
+			if len(knownFailures) > 0 {
+				fmt.Println()
+				log.Warnf("Tests failing in the baseline too:")
+				for _, name := range knownFailures {
+					log.Warnf("- %s", name)
+				}
+			}
+			if len(newFailures) > 0 {
+				fmt.Println()
+				log.Errorf("New failing tests (not failing in the baseline):")
+				for _, name := range newFailures {
+					log.Errorf("- %s", name)
 				}
+			}
+
+			if err := tools.ExportEnvironmentWithEnvman("BITRISE_XAMARIN_NEW_FAILED_TESTS", strings.Join(newFailures, "\n")); err != nil {
+				log.Warnf("Failed to export environment: %s, error: %s", "BITRISE_XAMARIN_NEW_FAILED_TESTS", err)
+			}
 
-				failf("Test failed, error: %s", err)
+			if failedRun != nil && configs.FailOnNewFailuresOnly == "yes" && len(newFailures) == 0 && onlyTestFailures(testRuns) {
+				fmt.Println()
+				log.Warnf("Every failing test fails in the baseline too, fail_on_new_failures_only is set, the tests are considered succeeded")
+				failedRun = nil
 			}
 		}
 	}
 
+	if failedRun != nil {
+		if configs.ExportFailureEvidence == "yes" {
+			if evidencePth, err := exportFailureEvidence(configs, testRuns); err != nil {
+				log.Warnf("Failed to export failure evidence, error: %s", err)
+			} else {
+				log.Donef("Failure evidence: %s", evidencePth)
+			}
+		}
+
+		exportTestResultLog(*failedRun, configs.DeployDir, configs.ExportFullResultsText == "yes")
+
+		failWithResult(testResult(testRuns), "Test failed, error: %s", failedRun.Err)
+	}
+
 	if err := tools.ExportEnvironmentWithEnvman("BITRISE_XAMARIN_TEST_RESULT", "succeeded"); err != nil {
 		log.Warnf("Failed to export environment: %s, error: %s", "BITRISE_XAMARIN_TEST_RESULT", err)
 	}
 
-	if resultLog != "" {
-		if err := tools.ExportEnvironmentWithEnvman("BITRISE_XAMARIN_TEST_FULL_RESULTS_TEXT", resultLog); err != nil {
-			log.Warnf("Failed to export environment: %s, error: %s", "BITRISE_XAMARIN_TEST_FULL_RESULTS_TEXT", err)
-		}
-	}
+	exportTestResultLog(testRuns[len(testRuns)-1], configs.DeployDir, configs.ExportFullResultsText == "yes")
 }
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+)
+
+// MergedTestSuiteModel is an NUnit3 test-suite element of the merged result xml.
+type MergedTestSuiteModel struct {
+	XMLName      xml.Name `xml:"test-suite"`
+	Type         string   `xml:"type,attr"`
+	Name         string   `xml:"name,attr"`
+	FullName     string   `xml:"fullname,attr"`
+	Result       string   `xml:"result,attr"`
+	TestCount    int      `xml:"testcasecount,attr"`
+	Total        int      `xml:"total,attr"`
+	Passed       int      `xml:"passed,attr"`
+	Failed       int      `xml:"failed,attr"`
+	Inconclusive int      `xml:"inconclusive,attr"`
+	Skipped      int      `xml:"skipped,attr"`
+	Duration     float64  `xml:"duration,attr"`
+
+	// Suites holds the raw assembly test-suite elements of an NUnit3 result xml.
+	Suites    string          `xml:",innerxml"`
+	TestCases []TestCaseModel `xml:"test-case"`
+}
+
+// MergedTestRunModel is the root of the merged NUnit3 result xml.
+type MergedTestRunModel struct {
+	XMLName      xml.Name               `xml:"test-run"`
+	ID           string                 `xml:"id,attr"`
+	Name         string                 `xml:"name,attr"`
+	Result       string                 `xml:"result,attr"`
+	TestCount    int                    `xml:"testcasecount,attr"`
+	Total        int                    `xml:"total,attr"`
+	Passed       int                    `xml:"passed,attr"`
+	Failed       int                    `xml:"failed,attr"`
+	Inconclusive int                    `xml:"inconclusive,attr"`
+	Skipped      int                    `xml:"skipped,attr"`
+	Duration     float64                `xml:"duration,attr"`
+	TestSuites   []MergedTestSuiteModel `xml:"test-suite"`
+}
+
+func nunit3Result(failed bool) string {
+	if failed {
+		return testResultFailed
+	}
+	return testResultPassed
+}
+
+// nunit3TestSuites returns the raw test-suite elements of the test-run element, if the content is an NUnit3 result xml.
+func nunit3TestSuites(content []byte) (string, bool, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	decoder.CharsetReader = xmlCharsetReader
+
+	var suites bytes.Buffer
+	depth := 0
+	for {
+		offset := decoder.InputOffset()
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", false, err
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			if depth == 0 && element.Name.Local != "test-run" {
+				return "", false, nil
+			}
+			if depth == 1 && element.Name.Local == "test-suite" {
+				if err := decoder.Skip(); err != nil {
+					return "", false, err
+				}
+				suites.Write(content[offset:decoder.InputOffset()])
+				continue
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+
+	return suites.String(), true, nil
+}
+
+// mergeTestResults merges the result xmls of the test runs into a single NUnit3 result xml, one test suite per test run,
+// with the secret_env_keys values masked.
+// The test suites of the NUnit3 result xmls (including their environment info) are copied as they are,
+// the other results (NUnit 2.x, TRX) are converted to NUnit3 test cases.
+// The references to the screenshots and attachments point to the copies in the deploy dir.
+func mergeTestResults(testRuns []TestRunModel, deployDir string) (MergedTestRunModel, error) {
+	counts := testCounts(testRuns)
+	merged := MergedTestRunModel{
+		ID:           "0",
+		Name:         "Xamarin UITest",
+		TestCount:    counts.Total,
+		Total:        counts.Total,
+		Passed:       counts.Passed,
+		Failed:       counts.Failed,
+		Inconclusive: counts.Inconclusive,
+		Skipped:      counts.Skipped,
+		Duration:     counts.Duration,
+	}
+
+	failed := false
+	for _, testRun := range testRuns {
+		if testRun.Err != nil {
+			failed = true
+		}
+		if testRun.Results == nil {
+			continue
+		}
+
+		results := testRun.Results
+		suite := MergedTestSuiteModel{
+			Type:         "TestSuite",
+			Name:         testRun.FullName(),
+			FullName:     testRun.FullName(),
+			Result:       nunit3Result(testRun.Err != nil || results.Failed > 0),
+			TestCount:    results.Total,
+			Total:        results.Total,
+			Passed:       results.Passed,
+			Failed:       results.Failed,
+			Inconclusive: results.Inconclusive,
+			Skipped:      results.Skipped,
+			Duration:     results.Duration,
+		}
+
+		content, err := readTestResultContent(testRun.ResultLogPth)
+		if err != nil {
+			return MergedTestRunModel{}, err
+		}
+		suites, ok, err := nunit3TestSuites(content)
+		if err != nil {
+			return MergedTestRunModel{}, fmt.Errorf("Failed to parse test results (%s), error: %s", testRun.ResultLogPth, err)
+		}
+		if ok {
+			suite.Suites = maskSecretsInXML(rewriteArtifactReferences(suites, testRun.DeployedFiles, deployDir))
+		} else {
+			for _, testCase := range results.TestCases {
+				suite.TestCases = append(suite.TestCases, rewriteTestCaseArtifactReferences(testCase, testRun.DeployedFiles, deployDir))
+			}
+		}
+
+		merged.TestSuites = append(merged.TestSuites, suite)
+	}
+	merged.Result = nunit3Result(failed || counts.Failed > 0)
+
+	return merged, nil
+}
+
+func rewriteTestCaseArtifactReferences(testCase TestCaseModel, deployedFiles map[string]string, deployDir string) TestCaseModel {
+	rewrite := func(info *FailureModel) *FailureModel {
+		if info == nil {
+			return nil
+		}
+		return &FailureModel{
+			Message:    maskSecrets(rewriteArtifactReferences(info.Message, deployedFiles, deployDir)),
+			StackTrace: maskSecrets(rewriteArtifactReferences(info.StackTrace, deployedFiles, deployDir)),
+		}
+	}
+	testCase.Failure = rewrite(testCase.Failure)
+	testCase.Reason = rewrite(testCase.Reason)
+
+	attachments := []AttachmentModel{}
+	for _, attachment := range testCase.Attachments {
+		attachment.FilePath = rewriteArtifactReferences(attachment.FilePath, deployedFiles, deployDir)
+		attachments = append(attachments, attachment)
+	}
+	testCase.Attachments = attachments
+
+	return testCase
+}
+
+func writeMergedTestResults(pth, deployDir string, testRuns []TestRunModel) error {
+	merged, err := mergeTestResults(testRuns, deployDir)
+	if err != nil {
+		return err
+	}
+
+	content, err := xml.MarshalIndent(merged, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to serialize merged test results, error: %s", err)
+	}
+
+	return fileutil.WriteStringToFile(pth, xml.Header+string(content))
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-tools/go-xamarin/tools/nunit"
+	"github.com/hashicorp/go-version"
+)
+
+var (
+	// nunitConsoleRunnerPattern matches the nunit3-console.exe of the NUnit.ConsoleRunner NuGet package,
+	// both in the solution's packages dir (NUnit.ConsoleRunner.<version>) and in the global package cache (nunit.consolerunner/<version>).
+	nunitConsoleRunnerPattern = regexp.MustCompile(`(?i)nunit\.consolerunner[./](\d+(\.\d+)*)[^/]*/tools/nunit3-console\.exe$`)
+	// nunit2ConsoleRunnerPattern matches the nunit-console.exe of the NUnit.Runners (2.x) NuGet package.
+	nunit2ConsoleRunnerPattern = regexp.MustCompile(`(?i)nunit\.runners[./](2(\.\d+)*)[^/]*/tools/nunit-console\.exe$`)
+)
+
+// findNugetConsoleRunner searches the given dirs for the console runner of a NuGet package,
+// and returns the one of the latest package version. The first group of the pattern has to match the package version.
+func findNugetConsoleRunner(dirs []string, pattern *regexp.Regexp) (string, error) {
+	var latestPth string
+	var latestVersion *version.Version
+
+	for _, dir := range dirs {
+		if exist, err := pathutil.IsDirExists(dir); err != nil {
+			return "", fmt.Errorf("Failed to check if dir (%s) exist, error: %s", dir, err)
+		} else if !exist {
+			continue
+		}
+
+		pths, err := findFiles(dir, func(pth string) bool {
+			return pattern.MatchString(filepath.ToSlash(pth))
+		})
+		if err != nil {
+			return "", fmt.Errorf("Failed to search for console runner in (%s), error: %s", dir, err)
+		}
+
+		for _, pth := range pths {
+			match := pattern.FindStringSubmatch(filepath.ToSlash(pth))
+			packageVersion, err := version.NewVersion(match[1])
+			if err != nil {
+				continue
+			}
+			if latestVersion == nil || packageVersion.GreaterThan(latestVersion) {
+				latestPth = pth
+				latestVersion = packageVersion
+			}
+		}
+	}
+
+	return latestPth, nil
+}
+
+// resolveNunitConsolePath returns the nunit3-console.exe to use: the configured one, or the one installed
+// by the NUnit.ConsoleRunner NuGet package, or the system installed one (NUNIT_PATH).
+func resolveNunitConsolePath(configuredPth, solutionPth string) (string, error) {
+	if configuredPth != "" {
+		return configuredPth, nil
+	}
+
+	dirs := []string{
+		filepath.Join(filepath.Dir(solutionPth), "packages"),
+		filepath.Join(pathutil.UserHomeDir(), ".nuget", "packages", "nunit.consolerunner"),
+	}
+	if pth, err := findNugetConsoleRunner(dirs, nunitConsoleRunnerPattern); err != nil {
+		log.Warnf("Failed to search for the NUnit.ConsoleRunner package, error: %s", err)
+	} else if pth != "" {
+		return pth, nil
+	}
+
+	return nunit.SystemNunit3ConsolePath()
+}
+
+// checkNunitConsoleVersion checks whether the version of the nunit3-console is at least the minimum version.
+func checkNunitConsoleVersion(consoleVersion, minimumVersion string) error {
+	current, err := version.NewVersion(consoleVersion)
+	if err != nil {
+		return fmt.Errorf("Failed to parse nunit3-console version (%s), error: %s", consoleVersion, err)
+	}
+	minimum, err := version.NewVersion(minimumVersion)
+	if err != nil {
+		return fmt.Errorf("Failed to parse minimum version (%s), error: %s", minimumVersion, err)
+	}
+
+	if current.LessThan(minimum) {
+		return fmt.Errorf(`nunit3-console version (%s) is older than the minimum_nunit_version (%s)
+Install a newer NUnit console runner:
+- add the NUnit.ConsoleRunner (>= %s) NuGet package to the test project, or
+- install it with: nuget install NUnit.ConsoleRunner -Version %s -OutputDirectory packages, or
+- set nunit_console_path to a newer nunit3-console.exe`, consoleVersion, minimumVersion, minimumVersion, minimumVersion)
+	}
+	return nil
+}
+
+// nunitOptionDefault is the value of the nunit option inputs, which leaves the option on nunit3-console's default.
+const nunitOptionDefault = "default"
+
+// quoteFilterValue quotes the value for a test selection expression, if it contains non-word characters.
+func quoteFilterValue(value string) string {
+	for i := 0; i < len(value); i++ {
+		if !isFilterWordChar(value[i]) {
+			return "'" + strings.Replace(value, "'", "\\'", -1) + "'"
+		}
+	}
+	return value
+}
+
+// splitTestNames splits the comma or newline separated list of test names of the test_to_run input.
+// Commas within the arguments of a parameterized test, e.g. Tests.Add(1,2), do not separate names.
+func splitTestNames(testToRun string) []string {
+	names := []string{}
+	depth := 0
+	var quote rune
+	current := []rune{}
+
+	flush := func() {
+		if name := strings.TrimSpace(string(current)); name != "" {
+			names = append(names, name)
+		}
+		current = current[:0]
+	}
+
+	for _, r := range testToRun {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+		case r == '\n' || (r == ',' && depth == 0):
+			flush()
+			continue
+		}
+		current = append(current, r)
+	}
+	flush()
+
+	return names
+}
+
+// testParams returns the non-empty lines of the test_params input.
+func testParams(value string) []string {
+	params := []string{}
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			params = append(params, line)
+		}
+	}
+	return params
+}
+
+// testFilterExpression combines the test_filter, include_categories and exclude_categories inputs
+// into a single test selection expression.
+func testFilterExpression(configs ConfigsModel) string {
+	parts := []string{}
+
+	if configs.TestFilter != "" {
+		parts = append(parts, "("+configs.TestFilter+")")
+	}
+
+	if categories := splitList(configs.IncludeCategories); len(categories) > 0 {
+		conditions := []string{}
+		for _, category := range categories {
+			conditions = append(conditions, fmt.Sprintf("cat == %s", quoteFilterValue(category)))
+		}
+		parts = append(parts, "("+strings.Join(conditions, " || ")+")")
+	}
+
+	for _, category := range excludedCategories(configs) {
+		parts = append(parts, fmt.Sprintf("cat != %s", quoteFilterValue(category)))
+	}
+
+	return strings.Join(parts, " && ")
+}
+
+// nunitOptions returns the nunit3-console options configured by the step inputs.
+func nunitOptions(configs ConfigsModel) []string {
+	options := []string{}
+
+	if filter := testFilterExpression(configs); filter != "" {
+		options = append(options, "--where", filter)
+	}
+	if configs.NunitWorkers != "" {
+		options = append(options, "--workers="+configs.NunitWorkers)
+	}
+	if configs.NunitLabels != nunitOptionDefault {
+		options = append(options, "--labels="+configs.NunitLabels)
+	}
+	if timeout, _ := parseNonNegativeInt(configs.TestTimeoutSeconds); timeout > 0 {
+		options = append(options, fmt.Sprintf("--timeout=%d", timeout*1000))
+	}
+	if configs.StopOnFirstFailure == "yes" {
+		options = append(options, "--stoponerror")
+	}
+	for _, param := range testParams(configs.TestParams) {
+		options = append(options, "--params="+param)
+	}
+	if configs.NunitAgentArch == "x86" {
+		options = append(options, "--x86")
+	}
+	if configs.NunitProcess != nunitOptionDefault {
+		options = append(options, "--process="+configs.NunitProcess)
+	}
+	if configs.NunitDomain != nunitOptionDefault {
+		options = append(options, "--domain="+configs.NunitDomain)
+	}
+	if configs.TestSeed != "" {
+		options = append(options, "--seed="+configs.TestSeed)
+	}
+	if extraOptions, err := splitArgs(configs.NunitExtraOptions); err == nil {
+		options = append(options, extraOptions...)
+	}
+	if configs.NunitTrace != nunitOptionDefault {
+		options = append(options, "--trace="+configs.NunitTrace)
+	}
+	if configs.NunitAgents != "" {
+		options = append(options, "--agents="+configs.NunitAgents)
+	}
+	if configs.NunitWorkDir != "" {
+		options = append(options, "--work="+configs.NunitWorkDir)
+	}
+
+	return options
+}
+
+// resolveNunit2ConsolePath returns the nunit-console.exe (NUnit 2.x) to use: the configured one,
+// or the one installed by the NUnit.Runners NuGet package.
+func resolveNunit2ConsolePath(configuredPth, solutionPth string) (string, error) {
+	if configuredPth != "" {
+		return configuredPth, nil
+	}
+
+	dirs := []string{
+		filepath.Join(filepath.Dir(solutionPth), "packages"),
+		filepath.Join(pathutil.UserHomeDir(), ".nuget", "packages", "nunit.runners"),
+	}
+	pth, err := findNugetConsoleRunner(dirs, nunit2ConsoleRunnerPattern)
+	if err != nil {
+		return "", err
+	}
+	if pth == "" {
+		return "", fmt.Errorf("set nunit2_console_path or add the NUnit.Runners 2.x NuGet package to the solution")
+	}
+	return pth, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const backgroundProcessStopTimeout = 10 * time.Second
+
+// BackgroundProcessModel is a long running child process of the step,
+// it is stopped when the step finishes.
+type BackgroundProcessModel struct {
+	cmd        *exec.Cmd
+	done       chan error
+	outputFile *os.File
+
+	stopOnce sync.Once
+	stopErr  error
+}
+
+// startBackgroundProcess starts the given command without waiting for it to finish,
+// and registers a cleanup to stop it if the step fails.
+func startBackgroundProcess(out io.Writer, name string, args ...string) (*BackgroundProcessModel, error) {
+	cmd := command.New(name, args...)
+	cmd.SetStdout(out)
+	cmd.SetStderr(out)
+
+	log.Printf("$ %s", cmd.PrintableCommandArgs())
+
+	process := &BackgroundProcessModel{
+		cmd:  cmd.GetCmd(),
+		done: make(chan error, 1),
+	}
+	if err := process.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("Failed to start %s, error: %s", cmd.PrintableCommandArgs(), err)
+	}
+
+	go func() {
+		process.done <- process.cmd.Wait()
+	}()
+
+	registerCleanup(func() {
+		if err := process.Stop(); err != nil {
+			log.Warnf("%s", err)
+		}
+	})
+
+	return process, nil
+}
+
+// startBackgroundProcessWithOutputFile starts the command in the background and writes its output into the given file,
+// the file is closed when the process is stopped.
+func startBackgroundProcessWithOutputFile(pth string, name string, args ...string) (*BackgroundProcessModel, error) {
+	file, err := os.Create(pth)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create file (%s), error: %s", pth, err)
+	}
+
+	process, err := startBackgroundProcess(file, name, args...)
+	if err != nil {
+		if closeErr := file.Close(); closeErr != nil {
+			log.Warnf("Failed to close file (%s), error: %s", pth, closeErr)
+		}
+		return nil, err
+	}
+	process.outputFile = file
+
+	return process, nil
+}
+
+// Stop interrupts the process and waits for it to exit, the process is killed if it does not exit in time.
+func (process *BackgroundProcessModel) Stop() error {
+	process.stopOnce.Do(func() {
+		if err := process.cmd.Process.Signal(os.Interrupt); err != nil {
+			process.stopErr = fmt.Errorf("Failed to interrupt process (%d), error: %s", process.cmd.Process.Pid, err)
+			return
+		}
+
+		select {
+		case <-process.done:
+		case <-time.After(backgroundProcessStopTimeout):
+			if err := process.cmd.Process.Kill(); err != nil {
+				process.stopErr = fmt.Errorf("Failed to kill process (%d), error: %s", process.cmd.Process.Pid, err)
+				return
+			}
+			<-process.done
+			process.stopErr = fmt.Errorf("Process (%d) did not exit in %s, killed", process.cmd.Process.Pid, backgroundProcessStopTimeout)
+		}
+
+		if process.outputFile != nil {
+			if err := process.outputFile.Close(); err != nil && process.stopErr == nil {
+				process.stopErr = fmt.Errorf("Failed to close file (%s), error: %s", process.outputFile.Name(), err)
+			}
+		}
+	})
+
+	return process.stopErr
+}
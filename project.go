@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-tools/go-xamarin/analyzers/project"
+	"github.com/bitrise-tools/go-xamarin/analyzers/solution"
+	"github.com/bitrise-tools/go-xamarin/builder"
+	"github.com/bitrise-tools/go-xamarin/constants"
+	"github.com/bitrise-tools/go-xamarin/tools/buildtools"
+	"github.com/bitrise-tools/go-xamarin/tools/buildtools/msbuild"
+	"github.com/bitrise-tools/go-xamarin/tools/buildtools/xbuild"
+)
+
+func isProjectPath(pth string) bool {
+	ext := filepath.Ext(pth)
+	return ext == constants.CSProjExt || ext == constants.FSProjExt
+}
+
+func solutionProject(sln solution.Model, projectPth string) (project.Model, bool) {
+	for _, proj := range sln.ProjectMap {
+		if proj.Pth == projectPth {
+			return proj, true
+		}
+	}
+	return project.Model{}, false
+}
+
+// findSolutionForProject searches the project's directory and its parent directories
+// for a solution, which contains the given project.
+func findSolutionForProject(projectPth string) (string, error) {
+	absProjectPth, err := pathutil.AbsPath(projectPth)
+	if err != nil {
+		return "", fmt.Errorf("Failed to expand path (%s), error: %s", projectPth, err)
+	}
+
+	dir := filepath.Dir(absProjectPth)
+	for {
+		solutionPths, err := filepath.Glob(filepath.Join(dir, "*"+constants.SolutionExt))
+		if err != nil {
+			return "", fmt.Errorf("Failed to search for solutions in (%s), error: %s", dir, err)
+		}
+
+		for _, solutionPth := range solutionPths {
+			sln, err := solution.New(solutionPth, true)
+			if err != nil {
+				return "", fmt.Errorf("Failed to analyze solution (%s), error: %s", solutionPth, err)
+			}
+
+			if _, ok := solutionProject(sln, absProjectPth); ok {
+				return solutionPth, nil
+			}
+		}
+
+		parentDir := filepath.Dir(dir)
+		if parentDir == dir {
+			break
+		}
+		dir = parentDir
+	}
+
+	return "", fmt.Errorf("No solution found containing project: %s", projectPth)
+}
+
+func projectBuildCommand(solutionPth string, proj project.Model, configuration, platform string, buildTool buildtools.BuildTool) (*xbuild.Model, error) {
+	projectConfiguration, projectPlatform, err := splitProjectConfig(proj, configuration, platform)
+	if err != nil {
+		return nil, err
+	}
+
+	var command *xbuild.Model
+
+	if buildTool == buildtools.Msbuild {
+		command, err = msbuild.New(solutionPth, proj.Pth)
+	} else {
+		command, err = xbuild.New(solutionPth, proj.Pth)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	command.SetTarget("Build")
+	command.SetConfiguration(projectConfiguration)
+	if projectPlatform != "" {
+		command.SetPlatform(projectPlatform)
+	}
+
+	return command, nil
+}
+
+// buildXamarinUITestProject builds the given Xamarin.UITest project and the iOS projects it refers to,
+// instead of building the whole solution.
+func buildXamarinUITestProject(solutionPth, testProjectPth, configuration, platform string, buildTool buildtools.BuildTool, callback builder.BuildCommandCallback) ([]string, error) {
+	warnings := []string{}
+
+	sln, err := solution.New(solutionPth, true)
+	if err != nil {
+		return warnings, fmt.Errorf("Failed to analyze solution (%s), error: %s", solutionPth, err)
+	}
+
+	absTestProjectPth, err := pathutil.AbsPath(testProjectPth)
+	if err != nil {
+		return warnings, fmt.Errorf("Failed to expand path (%s), error: %s", testProjectPth, err)
+	}
+
+	testProj, ok := solutionProject(sln, absTestProjectPth)
+	if !ok {
+		return warnings, fmt.Errorf("Project (%s) not found in solution (%s)", testProjectPth, solutionPth)
+	}
+	if testProj.TestFramework != constants.TestFrameworkXamarinUITest {
+		return warnings, fmt.Errorf("Project (%s) is not a Xamarin.UITest project", testProj.Name)
+	}
+
+	projectsToBuild := []project.Model{}
+	for _, projectID := range testProj.ReferredProjectIDs {
+		referredProj, ok := sln.ProjectMap[projectID]
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("Project reference exist with project id: %s, but project not found in solution", projectID))
+			continue
+		}
+
+		if referredProj.SDK != constants.SDKIOS {
+			continue
+		}
+
+		projectsToBuild = append(projectsToBuild, referredProj)
+	}
+	if len(projectsToBuild) == 0 {
+		return warnings, fmt.Errorf("Test project (%s) does not refer to any iOS project", testProj.Name)
+	}
+	projectsToBuild = append(projectsToBuild, testProj)
+
+	for _, proj := range projectsToBuild {
+		buildCommand, err := projectBuildCommand(solutionPth, proj, configuration, platform, buildTool)
+		if err != nil {
+			return warnings, err
+		}
+
+		if callback != nil {
+			callback(sln.Name, proj.Name, proj.SDK, proj.TestFramework, buildCommand.PrintableCommand(), false)
+		}
+
+		if err := buildCommand.Run(); err != nil {
+			return warnings, err
+		}
+	}
+
+	return warnings, nil
+}
+
+func projectNameInSolution(solutionPth, projectPth string) (string, error) {
+	sln, err := solution.New(solutionPth, true)
+	if err != nil {
+		return "", fmt.Errorf("Failed to analyze solution (%s), error: %s", solutionPth, err)
+	}
+
+	absProjectPth, err := pathutil.AbsPath(projectPth)
+	if err != nil {
+		return "", fmt.Errorf("Failed to expand path (%s), error: %s", projectPth, err)
+	}
+
+	proj, ok := solutionProject(sln, absProjectPth)
+	if !ok {
+		return "", fmt.Errorf("Project (%s) not found in solution (%s)", projectPth, solutionPth)
+	}
+
+	return proj.Name, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const ramDiskVolumeNamePrefix = "SimulatorRAMDisk"
+
+var mountPointPattern = regexp.MustCompile(`(?m)^\s*Mount Point:\s*(.+)$`)
+
+// RAMDiskModel is a RAM disk, which holds the data dir of a simulator.
+type RAMDiskModel struct {
+	Device    string
+	MountPath string
+
+	sim             SimulatorModel
+	originalDataDir string
+}
+
+// createRAMDisk creates and mounts a HFS+ RAM disk of the given size for the simulator,
+// the volume is named after the simulator's UDID, so that every simulator gets its own disk.
+func createRAMDisk(sizeMB int, simID string) (*RAMDiskModel, error) {
+	// ram:// expects the size in 512 byte sectors
+	out, err := command.New("hdiutil", "attach", "-nomount", fmt.Sprintf("ram://%d", sizeMB*2048)).RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create RAM disk, output: %s, error: %s", out, err)
+	}
+	device := strings.TrimSpace(out)
+
+	volumeName := ramDiskVolumeNamePrefix + "-" + simID
+	if out, err := command.New("diskutil", "erasevolume", "HFS+", volumeName, device).RunAndReturnTrimmedCombinedOutput(); err != nil {
+		if detachErr := detachDisk(device); detachErr != nil {
+			log.Warnf("%s", detachErr)
+		}
+		return nil, fmt.Errorf("Failed to format RAM disk (%s), output: %s, error: %s", device, out, err)
+	}
+
+	mountPath, err := diskMountPoint(device)
+	if err != nil {
+		if detachErr := detachDisk(device); detachErr != nil {
+			log.Warnf("%s", detachErr)
+		}
+		return nil, err
+	}
+
+	return &RAMDiskModel{
+		Device:    device,
+		MountPath: mountPath,
+	}, nil
+}
+
+// diskMountPoint returns where the disk is mounted, macOS adds a suffix to the volume path
+// if a volume with the same name is already mounted.
+func diskMountPoint(device string) (string, error) {
+	out, err := command.New("diskutil", "info", device).RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("Failed to get info of disk (%s), output: %s, error: %s", device, out, err)
+	}
+
+	match := mountPointPattern.FindStringSubmatch(out)
+	if len(match) < 2 || strings.TrimSpace(match[1]) == "" {
+		return "", fmt.Errorf("Disk (%s) is not mounted", device)
+	}
+	return strings.TrimSpace(match[1]), nil
+}
+
+func detachDisk(device string) error {
+	if out, err := command.New("hdiutil", "detach", device, "-force").RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("Failed to detach disk (%s), output: %s, error: %s", device, out, err)
+	}
+	return nil
+}
+
+// RelocateSimulatorData moves the simulator's data dir onto the RAM disk and replaces it with a symlink.
+// The original data dir is kept aside and put back by Teardown.
+func (ramDisk *RAMDiskModel) RelocateSimulatorData(sim *SimulatorModel) error {
+	if err := shutdownSimulator(sim); err != nil {
+		return err
+	}
+
+	dataDir := sim.DataDir()
+	relocatedDataDir := filepath.Join(ramDisk.MountPath, sim.Info.ID)
+	if out, err := command.New("rsync", "-a", dataDir+"/", relocatedDataDir+"/").RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("Failed to copy simulator data to the RAM disk, output: %s, error: %s", out, err)
+	}
+
+	originalDataDir := dataDir + ".original"
+	if err := os.Rename(dataDir, originalDataDir); err != nil {
+		return fmt.Errorf("Failed to move aside simulator data dir, error: %s", err)
+	}
+	if err := os.Symlink(relocatedDataDir, dataDir); err != nil {
+		if renameErr := os.Rename(originalDataDir, dataDir); renameErr != nil {
+			log.Warnf("Failed to restore simulator data dir, error: %s", renameErr)
+		}
+		return fmt.Errorf("Failed to link simulator data dir to the RAM disk, error: %s", err)
+	}
+
+	ramDisk.sim = *sim
+	ramDisk.originalDataDir = originalDataDir
+
+	return nil
+}
+
+// Teardown shuts down the relocated simulator, puts back its original data dir and detaches the RAM disk.
+func (ramDisk *RAMDiskModel) Teardown() error {
+	if ramDisk.originalDataDir != "" {
+		ramDisk.sim.Info.Status = "Booted"
+		if err := shutdownSimulator(&ramDisk.sim); err != nil {
+			return err
+		}
+
+		dataDir := ramDisk.sim.DataDir()
+		if err := os.Remove(dataDir); err != nil {
+			return fmt.Errorf("Failed to remove simulator data dir link, error: %s", err)
+		}
+		if err := os.Rename(ramDisk.originalDataDir, dataDir); err != nil {
+			return fmt.Errorf("Failed to restore simulator data dir, error: %s", err)
+		}
+	}
+
+	return detachDisk(ramDisk.Device)
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	testResultPassed       = "Passed"
+	testResultFailed       = "Failed"
+	testResultSkipped      = "Skipped"
+	testResultInconclusive = "Inconclusive"
+)
+
+// FailureModel ...
+type FailureModel struct {
+	Message    string `xml:"message"`
+	StackTrace string `xml:"stack-trace"`
+}
+
+// AttachmentModel ...
+type AttachmentModel struct {
+	FilePath    string `xml:"filePath"`
+	Description string `xml:"description,omitempty"`
+}
+
+// TestCaseModel ...
+type TestCaseModel struct {
+	ID        string  `xml:"id,attr"`
+	Name      string  `xml:"name,attr"`
+	FullName  string  `xml:"fullname,attr"`
+	ClassName string  `xml:"classname,attr"`
+	Result    string  `xml:"result,attr"`
+	Label     string  `xml:"label,attr"`
+	Duration  float64 `xml:"duration,attr"`
+
+	Failure *FailureModel `xml:"failure"`
+	Reason  *FailureModel `xml:"reason"`
+
+	// Attachments are the files attached to the test case by TestContext.AddTestAttachment.
+	Attachments []AttachmentModel `xml:"attachments>attachment"`
+}
+
+// Fixture returns the name of the fixture (test class) the test case belongs to.
+func (testCase TestCaseModel) Fixture() string {
+	if testCase.ClassName != "" {
+		return testCase.ClassName
+	}
+	if idx := strings.LastIndex(testCase.FullName, "."); idx > 0 {
+		return testCase.FullName[:idx]
+	}
+	return ""
+}
+
+// TestResultsModel ...
+type TestResultsModel struct {
+	Total        int
+	Passed       int
+	Failed       int
+	Inconclusive int
+	Skipped      int
+	Duration     float64
+
+	TestCases []TestCaseModel
+}
+
+// FailedTestCases ...
+func (results TestResultsModel) FailedTestCases() []TestCaseModel {
+	failed := []TestCaseModel{}
+	for _, testCase := range results.TestCases {
+		if testCase.Result == testResultFailed {
+			failed = append(failed, testCase)
+		}
+	}
+	return failed
+}
+
+func intAttr(element xml.StartElement, name string) int {
+	for _, attr := range element.Attr {
+		if attr.Name.Local == name {
+			var value int
+			if _, err := fmt.Sscanf(attr.Value, "%d", &value); err == nil {
+				return value
+			}
+		}
+	}
+	return 0
+}
+
+func floatAttr(element xml.StartElement, name string) float64 {
+	for _, attr := range element.Attr {
+		if attr.Name.Local == name {
+			var value float64
+			if _, err := fmt.Sscanf(attr.Value, "%g", &value); err == nil {
+				return value
+			}
+		}
+	}
+	return 0
+}
+
+// nunit2TestResult maps the NUnit 2.x test case result to the NUnit3 one.
+func nunit2TestResult(result string) string {
+	switch result {
+	case "Success":
+		return testResultPassed
+	case "Failure", "Error", "Cancelled":
+		return testResultFailed
+	case "Inconclusive":
+		return testResultInconclusive
+	default:
+		return testResultSkipped
+	}
+}
+
+// nunit2TestCase converts an NUnit 2.x test case, which has the full name in the name attribute,
+// no classname attribute and the duration in the time attribute.
+func nunit2TestCase(testCase TestCaseModel, element xml.StartElement) TestCaseModel {
+	testCase.FullName = testCase.Name
+	name := testCase.Name
+	if idx := strings.Index(name, "("); idx > 0 {
+		name = name[:idx]
+	}
+	if idx := strings.LastIndex(name, "."); idx > 0 {
+		testCase.ClassName = name[:idx]
+		testCase.Name = testCase.Name[idx+1:]
+	}
+	testCase.Result = nunit2TestResult(testCase.Result)
+	testCase.Duration = floatAttr(element, "time")
+	return testCase
+}
+
+// parseTestResults parses an NUnit3, an NUnit 2.x or a TRX (dotnet test) result xml,
+// encoded in UTF-8 or UTF-16.
+// The xml is decoded element by element, so only the test cases are kept in the memory.
+func parseTestResults(reader io.Reader) (TestResultsModel, error) {
+	results := TestResultsModel{}
+	nunit2 := false
+
+	reader, err := utf8Reader(reader)
+	if err != nil {
+		return TestResultsModel{}, fmt.Errorf("Failed to read test results, error: %s", err)
+	}
+
+	decoder := xml.NewDecoder(reader)
+	decoder.CharsetReader = xmlCharsetReader
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return TestResultsModel{}, fmt.Errorf("Failed to parse test results, error: %s", err)
+		}
+
+		element, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch element.Name.Local {
+		case "TestRun":
+			return parseTRXResults(decoder)
+		case "test-run":
+			results.Total = intAttr(element, "total")
+			results.Passed = intAttr(element, "passed")
+			results.Failed = intAttr(element, "failed")
+			results.Inconclusive = intAttr(element, "inconclusive")
+			results.Skipped = intAttr(element, "skipped")
+			results.Duration = floatAttr(element, "duration")
+		case "test-results":
+			nunit2 = true
+			notRun := intAttr(element, "not-run")
+			results.Failed = intAttr(element, "failures") + intAttr(element, "errors")
+			results.Inconclusive = intAttr(element, "inconclusive")
+			results.Skipped = notRun
+			results.Total = intAttr(element, "total") + notRun
+			results.Passed = results.Total - results.Failed - results.Inconclusive - results.Skipped
+		case "test-suite":
+			if nunit2 && results.Duration == 0 {
+				results.Duration = floatAttr(element, "time")
+			}
+		case "test-case":
+			var testCase TestCaseModel
+			if err := decoder.DecodeElement(&testCase, &element); err != nil {
+				return TestResultsModel{}, fmt.Errorf("Failed to parse test case, error: %s", err)
+			}
+			if nunit2 {
+				testCase = nunit2TestCase(testCase, element)
+			}
+			results.TestCases = append(results.TestCases, testCase)
+		}
+	}
+
+	return results, nil
+}
+
+func parseTestResultsFile(pth string) (TestResultsModel, error) {
+	file, err := os.Open(pth)
+	if err != nil {
+		return TestResultsModel{}, fmt.Errorf("Failed to open file (%s), error: %s", pth, err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Warnf("Failed to close file (%s), error: %s", pth, err)
+		}
+	}()
+
+	return parseTestResults(file)
+}
+
+// truncateLines keeps the first limit lines of the text, limit 0 keeps every line.
+func truncateLines(text string, limit int) string {
+	lines := strings.Split(text, "\n")
+	if limit == 0 || len(lines) <= limit {
+		return text
+	}
+	return fmt.Sprintf("%s\n... (%d more lines)", strings.Join(lines[:limit], "\n"), len(lines)-limit)
+}
+
+// readTestResultContent returns the content of the result xml, converted to UTF-8.
+func readTestResultContent(pth string) ([]byte, error) {
+	file, err := os.Open(pth)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open file (%s), error: %s", pth, err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Warnf("Failed to close file (%s), error: %s", pth, err)
+		}
+	}()
+
+	reader, err := utf8Reader(file)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read file (%s), error: %s", pth, err)
+	}
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read file (%s), error: %s", pth, err)
+	}
+	return content, nil
+}
+
+// logFailedTestCases logs the failed test cases with their failure message and stack trace,
+// the stack traces are truncated to stackTraceLineLimit lines.
+func logFailedTestCases(testCases []TestCaseModel, stackTraceLineLimit int) {
+	for _, testCase := range testCases {
+		log.Errorf("%s", testCase.FullName)
+		if fixture := testCase.Fixture(); fixture != "" {
+			log.Printf("fixture: %s", fixture)
+		}
+		if testCase.Failure != nil {
+			if message := strings.TrimSpace(testCase.Failure.Message); message != "" {
+				log.Printf("message: %s", maskSecrets(message))
+			}
+			if stackTrace := strings.TrimSpace(testCase.Failure.StackTrace); stackTrace != "" {
+				log.Printf("stack trace:\n%s", maskSecrets(truncateLines(stackTrace, stackTraceLineLimit)))
+			}
+		}
+	}
+}
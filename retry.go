@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+func failedTestNames(results TestResultsModel) []string {
+	names := []string{}
+	for _, testCase := range results.FailedTestCases() {
+		names = append(names, testCase.FullName)
+	}
+	return names
+}
+
+// retryFailedTests re-runs the failed tests of the given results up to retryCount times.
+// It returns the tests which passed on retry (flaky tests) and the tests which are still failing:
+// a test only counts as flaky if the retry results report it as passed.
+// The test_list_path selection is not applied to the retries, only the failed tests are re-run.
+func retryFailedTests(runner TestRunner, dllPths []string, resultLogPth string, results TestResultsModel, retryCount int) ([]string, []string, error) {
+	flakyTests := []string{}
+	failedTests := failedTestNames(results)
+	retryRunner := withoutTestList(runner)
+
+	ext := filepath.Ext(resultLogPth)
+	for attempt := 1; attempt <= retryCount && len(failedTests) > 0; attempt++ {
+		fmt.Println()
+		log.Warnf("Retrying %d failed test(s), attempt %d/%d", len(failedTests), attempt, retryCount)
+
+		retryResultLogPth := fmt.Sprintf("%s-retry-%d%s", strings.TrimSuffix(resultLogPth, ext), attempt, ext)
+		runErr := retryRunner.Run(dllPths, strings.Join(failedTests, "\n"), retryResultLogPth)
+
+		retryResults, err := parseTestResultsFile(retryResultLogPth)
+		if err != nil {
+			if runErr != nil {
+				return flakyTests, failedTests, fmt.Errorf("%s, failed to parse test results: %s", runErr, err)
+			}
+			return flakyTests, failedTests, err
+		}
+
+		ran := map[string]bool{}
+		passed := map[string]bool{}
+		for _, testCase := range retryResults.TestCases {
+			ran[testCase.FullName] = true
+			if testCase.Result == testResultPassed {
+				passed[testCase.FullName] = true
+			}
+		}
+
+		remaining := []string{}
+		missing := 0
+		for _, name := range failedTests {
+			if passed[name] {
+				flakyTests = append(flakyTests, name)
+			} else {
+				remaining = append(remaining, name)
+			}
+			if !ran[name] {
+				missing++
+			}
+		}
+		failedTests = remaining
+
+		if runErr != nil && missing > 0 {
+			return flakyTests, failedTests, fmt.Errorf("%s, %d of the retried test(s) missing from the test results", runErr, missing)
+		}
+	}
+
+	return flakyTests, failedTests, nil
+}
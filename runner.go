@@ -0,0 +1,522 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/fileutil"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// TestRunner runs the tests of test assemblies and writes the results into a result xml.
+type TestRunner interface {
+	// Run runs the given tests (test names separated by commas or newlines, split by splitTestNames,
+	// all tests if empty) of the test assemblies.
+	Run(dllPths []string, testToRun, resultLogPth string) error
+}
+
+const (
+	resultFormatNunit3 = "nunit3"
+	resultFormatNunit2 = "nunit2"
+)
+
+// testOutputCaptureSize is the maximum size of the test runner output kept in the memory to look for the failure reason.
+const testOutputCaptureSize = 1024 * 1024
+
+// tailBuffer keeps the last size bytes written into it.
+type tailBuffer struct {
+	mutex sync.Mutex
+	size  int
+	data  []byte
+}
+
+// Write ...
+func (buffer *tailBuffer) Write(p []byte) (int, error) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	buffer.data = append(buffer.data, p...)
+	if overflow := len(buffer.data) - buffer.size; overflow > 0 {
+		buffer.data = append(buffer.data[:0], buffer.data[overflow:]...)
+	}
+	return len(p), nil
+}
+
+// String ...
+func (buffer *tailBuffer) String() string {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	return string(buffer.data)
+}
+
+var testOutputErrorPattern = regexp.MustCompile(`(?m)^.*(\w+Exception|\b[Ee]rror( [A-Z]+[0-9]+)?): \S.*$`)
+
+// testOutputError returns the first line of the test runner output reporting an error,
+// it describes the failure if the test runner failed before writing the test results.
+func testOutputError(output string) string {
+	return strings.TrimSpace(testOutputErrorPattern.FindString(output))
+}
+
+// runTestCommand runs the test runner command, with the test_env_vars environment variables
+// set only for the command.
+// The output is streamed to the log while the command runs,
+// and its last part is checked for the failure reason if the command fails.
+func runTestCommand(title string, cmdSlice, envs []string) error {
+	fmt.Println()
+	log.Infof(title)
+	log.Donef("$ %s", command.PrintableCommandArgs(true, cmdSlice))
+	fmt.Println()
+
+	cmd, err := command.NewFromSlice(cmdSlice)
+	if err != nil {
+		return err
+	}
+	if len(envs) > 0 {
+		cmd.AppendEnvs(envs...)
+	}
+
+	output := &tailBuffer{size: testOutputCaptureSize}
+	cmd.SetStdout(io.MultiWriter(os.Stdout, output))
+	cmd.SetStderr(io.MultiWriter(os.Stderr, output))
+
+	if err := cmd.Run(); err != nil {
+		if reason := testOutputError(output.String()); reason != "" {
+			return fmt.Errorf("%s: %s", err, reason)
+		}
+		return err
+	}
+	return nil
+}
+
+// nunit3Runner runs the tests with nunit3-console.
+type nunit3Runner struct {
+	monoPth    string
+	consolePth string
+	// options are the custom options of the console
+	options []string
+	// resultFormat is the format of the result xml, nunit3 or nunit2
+	resultFormat string
+	// testListPth is the file listing the tests to run (test_list_path), the console runs these and the given tests
+	testListPth string
+	envs        []string
+}
+
+func (runner nunit3Runner) commandSlice(dllPths []string, testToRun, resultLogPth string) []string {
+	cmdSlice := append([]string{runner.monoPth, runner.consolePth}, dllPths...)
+	for _, name := range splitTestNames(testToRun) {
+		cmdSlice = append(cmdSlice, "--test", name)
+	}
+	if runner.testListPth != "" {
+		cmdSlice = append(cmdSlice, "--testlist", runner.testListPth)
+	}
+	if resultLogPth != "" {
+		if runner.resultFormat == resultFormatNunit2 {
+			resultLogPth += ";format=nunit2"
+		}
+		cmdSlice = append(cmdSlice, "--result", resultLogPth)
+	}
+	return append(cmdSlice, runner.options...)
+}
+
+// Run ...
+func (runner nunit3Runner) Run(dllPths []string, testToRun, resultLogPth string) error {
+	return runTestCommand("Running Xamarin UITest", runner.commandSlice(dllPths, testToRun, resultLogPth), runner.envs)
+}
+
+// withoutTestList returns the runner without the test_list_path selection,
+// to run only the given tests (e.g. the failed tests on retry).
+func withoutTestList(runner TestRunner) TestRunner {
+	switch r := runner.(type) {
+	case nunit3Runner:
+		r.testListPth = ""
+		return r
+	case nunit2Runner:
+		r.testListPth = ""
+		return r
+	}
+	return runner
+}
+
+// nunit2Runner runs the tests of NUnit 2.x test assemblies with nunit-console.
+type nunit2Runner struct {
+	monoPth     string
+	consolePth  string
+	options     []string
+	testListPth string
+	envs        []string
+}
+
+func (runner nunit2Runner) commandSlice(dllPths []string, runListPth, resultLogPth string) []string {
+	cmdSlice := append(append([]string{runner.monoPth, runner.consolePth}, dllPths...), "-nologo")
+	if runListPth != "" {
+		cmdSlice = append(cmdSlice, "-runlist="+runListPth)
+	}
+	if resultLogPth != "" {
+		cmdSlice = append(cmdSlice, "-result="+resultLogPth)
+	}
+	return append(cmdSlice, runner.options...)
+}
+
+// runList returns the file listing the tests to run: the test_list_path if no test name is given,
+// otherwise a temporary file listing the given tests and the tests of the test_list_path.
+// The names are not passed with -run, as nunit-console splits it on every comma,
+// including the commas of the parameterized test names.
+func (runner nunit2Runner) runList(testToRun string) (string, error) {
+	names := splitTestNames(testToRun)
+	if len(names) == 0 {
+		return runner.testListPth, nil
+	}
+
+	if runner.testListPth != "" {
+		content, err := fileutil.ReadStringFromFile(runner.testListPth)
+		if err != nil {
+			return "", fmt.Errorf("Failed to read test list (%s), error: %s", runner.testListPth, err)
+		}
+		names = append(names, splitLines(content)...)
+	}
+
+	tmpDir, err := pathutil.NormalizedOSTempDirPath("test-run-list")
+	if err != nil {
+		return "", fmt.Errorf("Failed to create tmp dir, error: %s", err)
+	}
+	runListPth := filepath.Join(tmpDir, "tests.txt")
+	if err := fileutil.WriteStringToFile(runListPth, strings.Join(names, "\n")+"\n"); err != nil {
+		return "", fmt.Errorf("Failed to write test list, error: %s", err)
+	}
+	return runListPth, nil
+}
+
+// Run ...
+func (runner nunit2Runner) Run(dllPths []string, testToRun, resultLogPth string) error {
+	runListPth, err := runner.runList(testToRun)
+	if err != nil {
+		return err
+	}
+	return runTestCommand("Running Xamarin UITest (NUnit 2)", runner.commandSlice(dllPths, runListPth, resultLogPth), runner.envs)
+}
+
+// nunit2Options returns the nunit-console (NUnit 2.x) options configured by the step inputs,
+// and warnings about the inputs NUnit 2.x does not support.
+func nunit2Options(configs ConfigsModel) ([]string, []string) {
+	options := []string{}
+	warnings := []string{}
+
+	if categories := splitList(configs.IncludeCategories); len(categories) > 0 {
+		options = append(options, "-include="+strings.Join(categories, ","))
+	}
+	if categories := excludedCategories(configs); len(categories) > 0 {
+		options = append(options, "-exclude="+strings.Join(categories, ","))
+	}
+	if timeout, _ := parseNonNegativeInt(configs.TestTimeoutSeconds); timeout > 0 {
+		options = append(options, fmt.Sprintf("-timeout=%d", timeout*1000))
+	}
+	if configs.StopOnFirstFailure == "yes" {
+		options = append(options, "-stoponerror")
+	}
+	if configs.NunitLabels != nunitOptionDefault && configs.NunitLabels != "Off" {
+		options = append(options, "-labels")
+	}
+	if configs.NunitProcess != nunitOptionDefault {
+		options = append(options, "-process="+configs.NunitProcess)
+	}
+	if configs.NunitDomain != nunitOptionDefault {
+		options = append(options, "-domain="+configs.NunitDomain)
+	}
+	if configs.NunitWorkDir != "" {
+		options = append(options, "-work="+configs.NunitWorkDir)
+	}
+
+	if configs.TestFilter != "" {
+		warnings = append(warnings, "test_filter is not supported by NUnit 2.x, use include_categories, exclude_categories or test_to_run instead")
+	}
+	if configs.NunitWorkers != "" {
+		warnings = append(warnings, "nunit_workers is not supported by NUnit 2.x")
+	}
+	if len(testParams(configs.TestParams)) > 0 {
+		warnings = append(warnings, "test_params is not supported by NUnit 2.x")
+	}
+	if configs.TestSeed != "" {
+		warnings = append(warnings, "test_seed is only used to order the test projects, NUnit 2.x does not support random seed")
+	}
+	if configs.NunitAgentArch == "x86" {
+		warnings = append(warnings, "nunit_agent_arch: x86 is not supported by NUnit 2.x, use the nunit-console-x86.exe as nunit2_console_path instead")
+	}
+
+	return options, warnings
+}
+
+// dotnetTestRunner runs the tests with `dotnet test`, which writes a TRX result file.
+type dotnetTestRunner struct {
+	categoryFilter string
+	options        []string
+	runSettings    []string
+	envs           []string
+}
+
+var dotnetTestFilterEscaper = strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`, "&", `\&`, "|", `\|`, "=", `\=`, "!", `\!`, "~", `\~`)
+
+// dotnetTestFilter converts the list of test names into a `dotnet test --filter` expression,
+// a name selects the test with the given full name and the tests of the namespace or fixture with the given name.
+func dotnetTestFilter(testToRun string) string {
+	conditions := []string{}
+	for _, name := range splitTestNames(testToRun) {
+		name = dotnetTestFilterEscaper.Replace(name)
+		conditions = append(conditions, "FullyQualifiedName="+name, "FullyQualifiedName~"+name+".")
+	}
+	return strings.Join(conditions, "|")
+}
+
+// dotnetTestCategoryFilter converts the include_categories and exclude_categories inputs into a `dotnet test --filter` expression,
+// categoryProperty is the test property holding the categories (TestCategory for NUnit, Category for xUnit.net).
+func dotnetTestCategoryFilter(configs ConfigsModel, categoryProperty string) string {
+	parts := []string{}
+
+	if categories := splitList(configs.IncludeCategories); len(categories) > 0 {
+		conditions := []string{}
+		for _, category := range categories {
+			conditions = append(conditions, categoryProperty+"="+dotnetTestFilterEscaper.Replace(category))
+		}
+		parts = append(parts, "("+strings.Join(conditions, "|")+")")
+	}
+
+	for _, category := range excludedCategories(configs) {
+		parts = append(parts, categoryProperty+"!="+dotnetTestFilterEscaper.Replace(category))
+	}
+
+	return strings.Join(parts, "&")
+}
+
+func (runner dotnetTestRunner) commandSlice(dllPths []string, testToRun, resultLogPth string) []string {
+	cmdSlice := append([]string{"dotnet", "test"}, dllPths...)
+	if resultLogPth != "" {
+		cmdSlice = append(cmdSlice, "--logger", "trx;LogFileName="+filepath.Base(resultLogPth), "--results-directory", filepath.Dir(resultLogPth))
+	}
+
+	filters := []string{}
+	if filter := dotnetTestFilter(testToRun); filter != "" {
+		filters = append(filters, "("+filter+")")
+	}
+	if runner.categoryFilter != "" {
+		filters = append(filters, "("+runner.categoryFilter+")")
+	}
+	if len(filters) > 0 {
+		cmdSlice = append(cmdSlice, "--filter", strings.Join(filters, "&"))
+	}
+
+	cmdSlice = append(cmdSlice, runner.options...)
+	if len(runner.runSettings) > 0 {
+		cmdSlice = append(append(cmdSlice, "--"), runner.runSettings...)
+	}
+	return cmdSlice
+}
+
+// Run ...
+func (runner dotnetTestRunner) Run(dllPths []string, testToRun, resultLogPth string) error {
+	return runTestCommand("Running Xamarin UITest (dotnet test)", runner.commandSlice(dllPths, testToRun, resultLogPth), runner.envs)
+}
+
+// newDotnetTestRunner returns the `dotnet test` runner configured by the step inputs,
+// and warnings about the inputs `dotnet test` does not support.
+// The NUnit specific inputs are passed to the NUnit test adapter as run settings,
+// for xUnit.net test assemblies they are not supported.
+func newDotnetTestRunner(configs ConfigsModel, xunit bool) (dotnetTestRunner, []string) {
+	warnings := []string{}
+
+	if xunit {
+		runner := dotnetTestRunner{categoryFilter: dotnetTestCategoryFilter(configs, "Category"), envs: splitLines(configs.TestEnvVars)}
+		if configs.XamarinConfiguration != "" {
+			runner.options = append(runner.options, "--configuration", configs.XamarinConfiguration)
+		}
+		if configs.TestFilter != "" || configs.NunitWorkers != "" || configs.StopOnFirstFailure == "yes" || len(testParams(configs.TestParams)) > 0 {
+			warnings = append(warnings, "test_filter, nunit_workers, stop_on_first_failure and test_params are not supported by dotnet test for xUnit.net test assemblies")
+		}
+		return runner, warnings
+	}
+
+	runner := dotnetTestRunner{categoryFilter: dotnetTestCategoryFilter(configs, "TestCategory"), envs: splitLines(configs.TestEnvVars)}
+
+	if configs.XamarinConfiguration != "" {
+		runner.options = append(runner.options, "--configuration", configs.XamarinConfiguration)
+	}
+
+	if configs.TestFilter != "" {
+		runner.runSettings = append(runner.runSettings, "NUnit.Where="+configs.TestFilter)
+	}
+	if configs.NunitWorkers != "" {
+		runner.runSettings = append(runner.runSettings, "NUnit.NumberOfTestWorkers="+configs.NunitWorkers)
+	}
+	if timeout, _ := parseNonNegativeInt(configs.TestTimeoutSeconds); timeout > 0 {
+		runner.runSettings = append(runner.runSettings, fmt.Sprintf("NUnit.DefaultTimeout=%d", timeout*1000))
+	}
+	if configs.StopOnFirstFailure == "yes" {
+		runner.runSettings = append(runner.runSettings, "NUnit.StopOnError=true")
+	}
+	if configs.TestSeed != "" {
+		runner.runSettings = append(runner.runSettings, "NUnit.RandomSeed="+configs.TestSeed)
+	}
+	for _, param := range testParams(configs.TestParams) {
+		split := strings.SplitN(param, "=", 2)
+		runner.runSettings = append(runner.runSettings, fmt.Sprintf(`TestRunParameters.Parameter(name="%s", value="%s")`, split[0], split[1]))
+	}
+
+	if configs.TestListPath != "" {
+		warnings = append(warnings, "test_list_path is not supported by dotnet test, use test_to_run or test_filter instead")
+	}
+	if configs.ResultFormat == resultFormatNunit2 {
+		warnings = append(warnings, "result_format: nunit2 is not supported by dotnet test, the results are written in TRX format")
+	}
+	if configs.NunitLabels != nunitOptionDefault {
+		warnings = append(warnings, "nunit_labels is not supported by dotnet test")
+	}
+	if configs.NunitAgentArch != nunitOptionDefault || configs.NunitProcess != nunitOptionDefault || configs.NunitDomain != nunitOptionDefault {
+		warnings = append(warnings, "nunit_agent_arch, nunit_process and nunit_domain are not supported by dotnet test")
+	}
+
+	return runner, warnings
+}
+
+var assemblyVersionPattern = regexp.MustCompile(`(?m)^Version:\s*(\d+(?:\.\d+)*)`)
+
+// assemblyVersion returns the version of the .NET assembly, read by monodis.
+func assemblyVersion(monoPth, assemblyPth string) (string, error) {
+	monodis := filepath.Join(filepath.Dir(monoPth), "monodis")
+	out, err := command.New(monodis, "--assembly", assemblyPth).RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("Failed to read assembly info of (%s), output: %s, error: %s", assemblyPth, out, err)
+	}
+
+	match := assemblyVersionPattern.FindStringSubmatch(out)
+	if match == nil {
+		return "", fmt.Errorf("No version found in the assembly info of (%s): %s", assemblyPth, out)
+	}
+	return match[1], nil
+}
+
+// nunitMajorVersion returns the major version of the nunit.framework.dll next to the test assembly.
+func nunitMajorVersion(monoPth, dllPth string) (int, error) {
+	frameworkPth := filepath.Join(filepath.Dir(dllPth), "nunit.framework.dll")
+	if exist, err := pathutil.IsPathExists(frameworkPth); err != nil {
+		return 0, fmt.Errorf("Failed to check if file (%s) exist, error: %s", frameworkPth, err)
+	} else if !exist {
+		return 0, fmt.Errorf("nunit.framework.dll not found next to the test assembly: %s", dllPth)
+	}
+
+	frameworkVersion, err := assemblyVersion(monoPth, frameworkPth)
+	if err != nil {
+		return 0, err
+	}
+
+	var major int
+	if _, err := fmt.Sscanf(frameworkVersion, "%d", &major); err != nil {
+		return 0, fmt.Errorf("Failed to parse version (%s), error: %s", frameworkVersion, err)
+	}
+	return major, nil
+}
+
+const (
+	testRunnerNunitConsole = "nunit-console"
+	testRunnerDotnetTest   = "dotnet-test"
+	testRunnerAuto         = "auto"
+)
+
+// TestRunnersModel picks the test runner of the test projects.
+type TestRunnersModel struct {
+	configs ConfigsModel
+	// sdkStyleTestProjects holds the names of the SDK-style test projects, used by the auto test runner mode.
+	sdkStyleTestProjects map[string]bool
+
+	nunit3      TestRunner
+	nunit2      TestRunner
+	xunit       TestRunner
+	dotnet      TestRunner
+	dotnetXunit TestRunner
+}
+
+// ForTestProject returns the runner of the test project's test assembly:
+// `dotnet test` in dotnet-test mode and for SDK-style test projects in auto mode,
+// otherwise xunit.console for xUnit.net test assemblies, nunit-console for NUnit 2.x test assemblies
+// and nunit3-console for all the others.
+func (runners *TestRunnersModel) ForTestProject(testProjectName, dllPth string) (TestRunner, error) {
+	xunit, err := isXunitAssembly(dllPth)
+	if err != nil {
+		return nil, err
+	}
+
+	if runners.configs.TestRunner == testRunnerDotnetTest || (runners.configs.TestRunner == testRunnerAuto && runners.sdkStyleTestProjects[testProjectName]) {
+		return runners.forDotnetTest(xunit), nil
+	}
+
+	if xunit {
+		return runners.forXunitDLL()
+	}
+	return runners.forNunitDLL(dllPth)
+}
+
+func (runners *TestRunnersModel) forDotnetTest(xunit bool) TestRunner {
+	runner := &runners.dotnet
+	if xunit {
+		runner = &runners.dotnetXunit
+	}
+
+	if *runner == nil {
+		dotnetRunner, warnings := newDotnetTestRunner(runners.configs, xunit)
+		for _, warning := range warnings {
+			log.Warnf("%s", warning)
+		}
+		*runner = dotnetRunner
+	}
+	return *runner
+}
+
+func (runners *TestRunnersModel) forXunitDLL() (TestRunner, error) {
+	if runners.xunit == nil {
+		consolePth, err := resolveXunitConsolePath(runners.configs.XunitConsolePath, runners.configs.XamarinSolution)
+		if err != nil {
+			return nil, fmt.Errorf("Test assembly uses xUnit.net, but xunit.console.exe not found: %s", err)
+		}
+		log.Printf("xunit.console: %s", consolePth)
+
+		options, warnings := xunitOptions(runners.configs)
+		for _, warning := range warnings {
+			log.Warnf("%s", warning)
+		}
+
+		runners.xunit = xunitRunner{monoPth: runners.configs.MonoPath, consolePth: consolePth, options: options, envs: splitLines(runners.configs.TestEnvVars)}
+	}
+	return runners.xunit, nil
+}
+
+func (runners *TestRunnersModel) forNunitDLL(dllPth string) (TestRunner, error) {
+	major, err := nunitMajorVersion(runners.configs.MonoPath, dllPth)
+	if err != nil {
+		log.Warnf("Failed to detect NUnit version, using nunit3-console, error: %s", err)
+		return runners.nunit3, nil
+	}
+	if major >= 3 {
+		return runners.nunit3, nil
+	}
+
+	if runners.nunit2 == nil {
+		consolePth, err := resolveNunit2ConsolePath(runners.configs.Nunit2ConsolePath, runners.configs.XamarinSolution)
+		if err != nil {
+			return nil, fmt.Errorf("Test assembly (%s) uses NUnit %d, but nunit-console.exe not found: %s", filepath.Base(dllPth), major, err)
+		}
+		log.Printf("nunit-console: %s", consolePth)
+
+		options, warnings := nunit2Options(runners.configs)
+		for _, warning := range warnings {
+			log.Warnf("%s", warning)
+		}
+
+		runners.nunit2 = nunit2Runner{monoPth: runners.configs.MonoPath, consolePth: consolePth, options: options, testListPth: runners.configs.TestListPath, envs: splitLines(runners.configs.TestEnvVars)}
+	}
+	return runners.nunit2, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"os"
+	"sort"
+	"strings"
+)
+
+// secretValues holds the values masked in the printed failures and the exported results.
+var secretValues []string
+
+// secretMask replaces the secret values.
+const secretMask = "[REDACTED]"
+
+// secretEnvValues returns the values of the secret_env_keys envs, read from the test_env_vars or the environment,
+// the longest first, so a secret is not masked partially by another one.
+func secretEnvValues(secretEnvKeys string, testEnvs []string) []string {
+	testEnvValues := map[string]string{}
+	for _, env := range testEnvs {
+		if keyValue := strings.SplitN(env, "=", 2); len(keyValue) == 2 {
+			testEnvValues[keyValue[0]] = keyValue[1]
+		}
+	}
+
+	values := []string{}
+	for _, key := range splitList(strings.Replace(secretEnvKeys, "\n", ",", -1)) {
+		value, ok := testEnvValues[key]
+		if !ok {
+			value = os.Getenv(key)
+		}
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+
+	values = uniqueStrings(values)
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+	return values
+}
+
+// maskSecretsInXML replaces the secret values in the raw xml, both as they are and escaped.
+func maskSecretsInXML(text string) string {
+	for _, value := range secretValues {
+		var escaped bytes.Buffer
+		if err := xml.EscapeText(&escaped, []byte(value)); err == nil && escaped.String() != value {
+			text = strings.Replace(text, escaped.String(), secretMask, -1)
+		}
+		text = strings.Replace(text, value, secretMask, -1)
+	}
+	return text
+}
+
+// maskSecrets replaces the secret values in the text.
+func maskSecrets(text string) string {
+	for _, value := range secretValues {
+		text = strings.Replace(text, value, secretMask, -1)
+	}
+	return text
+}
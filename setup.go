@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const interfaceStyleDefault = "default"
+
+// prepareSimulator applies the configured settings to the simulator and boots it.
+// Settings stored in the simulator's data dir are written before the boot, the others are applied on the booted simulator.
+// If warm_up_app is enabled, the given apps are launched once, before the tests.
+func prepareSimulator(configs ConfigsModel, sim *SimulatorModel, appPths []string) {
+	if configs.EraseSimulator == "yes" {
+		fmt.Println()
+		log.Infof("Erasing simulator: %s", sim.Name())
+		if err := eraseSimulator(sim); err != nil {
+			failf("Failed to erase simulator, error: %s", err)
+		}
+	}
+
+	if configs.SimulatorLanguage != "" || configs.SimulatorLocale != "" {
+		fmt.Println()
+		log.Infof("Setting simulator language (%s) and locale (%s)", configs.SimulatorLanguage, configs.SimulatorLocale)
+		if err := setSimulatorLanguageAndLocale(sim, configs.SimulatorLanguage, configs.SimulatorLocale); err != nil {
+			failf("Failed to set simulator language and locale, error: %s", err)
+		}
+	}
+
+	if configs.DisconnectHardwareKeyboard == "yes" {
+		fmt.Println()
+		log.Infof("Disconnecting hardware keyboard")
+		if err := shutdownSimulator(sim); err != nil {
+			failf("Failed to shut down simulator, error: %s", err)
+		}
+		if err := disconnectHardwareKeyboard(*sim); err != nil {
+			failf("Failed to disconnect hardware keyboard, error: %s", err)
+		}
+	}
+
+	if configs.UseRAMDisk == "yes" {
+		sizeMB, _ := parseNonNegativeInt(configs.RAMDiskSizeMB)
+
+		fmt.Println()
+		log.Infof("Moving simulator data onto a %d MB RAM disk", sizeMB)
+		ramDisk, err := createRAMDisk(sizeMB, sim.Info.ID)
+		if err != nil {
+			failf("Failed to create RAM disk, error: %s", err)
+		}
+		registerCleanup(func() {
+			if err := ramDisk.Teardown(); err != nil {
+				log.Warnf("Failed to tear down RAM disk, error: %s", err)
+			}
+		})
+
+		if err := ramDisk.RelocateSimulatorData(sim); err != nil {
+			failf("Failed to move simulator data onto the RAM disk, error: %s", err)
+		}
+		log.Donef("Simulator data moved onto: %s", ramDisk.MountPath)
+	}
+
+	boot(configs, sim)
+
+	if configs.KeepSimulatorAlive == "no" {
+		bootedSim := *sim
+		eraseAfterTests := configs.EraseSimulator == "yes"
+		registerCleanup(func() {
+			fmt.Println()
+			log.Infof("Shutting down simulator: %s", bootedSim.Name())
+			bootedSim.Info.Status = "Booted"
+			if err := shutdownSimulator(&bootedSim); err != nil {
+				log.Warnf("Failed to shut down simulator, error: %s", err)
+				return
+			}
+
+			if eraseAfterTests {
+				if _, err := simctl("erase", bootedSim.Info.ID); err != nil {
+					log.Warnf("Failed to erase simulator, error: %s", err)
+				}
+			}
+		})
+	}
+
+	if configs.OverrideStatusBar == "yes" {
+		overriddenSim := *sim
+		registerCleanup(func() {
+			if err := clearStatusBar(overriddenSim); err != nil {
+				log.Warnf("Failed to clear simulator status bar override, error: %s", err)
+			}
+		})
+	}
+
+	applyBootedSimulatorSettings(configs, *sim)
+
+	if appPths := splitList(configs.AdditionalAppPaths); len(appPths) > 0 {
+		fmt.Println()
+		log.Infof("Installing additional apps")
+		for _, appPth := range appPths {
+			if err := installApp(*sim, appPth); err != nil {
+				failf("Failed to install app, error: %s", err)
+			}
+		}
+	}
+
+	if configs.WarmUpApp == "yes" {
+		for _, appPth := range appPths {
+			fmt.Println()
+			log.Infof("Warming up app: %s", appPth)
+			if err := warmUpApp(*sim, appPth); err != nil {
+				failf("Failed to warm up app, error: %s", err)
+			}
+		}
+	}
+
+	if configs.SnapshotSimulator == "yes" {
+		fmt.Println()
+		log.Infof("Taking snapshot of the simulator data")
+		if err := snapshotSimulatorData(sim); err != nil {
+			failf("Failed to take snapshot of the simulator data, error: %s", err)
+		}
+
+		snapshotDir := sim.SnapshotDir
+		registerCleanup(func() {
+			if err := os.RemoveAll(snapshotDir); err != nil {
+				log.Warnf("Failed to remove simulator snapshot (%s), error: %s", snapshotDir, err)
+			}
+		})
+
+		rebootSimulator(configs, sim)
+	}
+}
+
+// restoreSimulator restores the simulator data from the snapshot taken by prepareSimulator, then boots the simulator.
+func restoreSimulator(configs ConfigsModel, sim *SimulatorModel) {
+	fmt.Println()
+	log.Infof("Restoring simulator data from snapshot")
+	if err := restoreSimulatorData(sim); err != nil {
+		failf("Failed to restore simulator data, error: %s", err)
+	}
+
+	rebootSimulator(configs, sim)
+}
+
+func rebootSimulator(configs ConfigsModel, sim *SimulatorModel) {
+	boot(configs, sim)
+	applyBootedSimulatorSettings(configs, *sim)
+}
+
+func boot(configs ConfigsModel, sim *SimulatorModel) {
+	attempts, _ := parseNonNegativeInt(configs.SimulatorBootAttempts)
+	if attempts == 0 {
+		attempts = 1
+	}
+	delay, _ := parseNonNegativeInt(configs.SimulatorBootRetryDelay)
+
+	fmt.Println()
+	log.Infof("Booting simulator: %s", sim.Name())
+	if err := bootSimulatorWithRetry(sim, attempts, time.Duration(delay)*time.Second); err != nil {
+		failf("Failed to boot simulator, error: %s", err)
+	}
+	log.Donef("Simulator booted")
+}
+
+// applyBootedSimulatorSettings applies the settings, which do not survive a simulator reboot.
+func applyBootedSimulatorSettings(configs ConfigsModel, sim SimulatorModel) {
+	if configs.SimulatorTimezone != "" {
+		fmt.Println()
+		log.Infof("Setting simulator timezone: %s", configs.SimulatorTimezone)
+		if err := setSimulatorTimezone(sim, configs.SimulatorTimezone); err != nil {
+			failf("Failed to set simulator timezone, error: %s", err)
+		}
+	}
+
+	if configs.InterfaceStyle != interfaceStyleDefault {
+		fmt.Println()
+		log.Infof("Setting simulator appearance: %s", configs.InterfaceStyle)
+		if err := setInterfaceStyle(sim, configs.InterfaceStyle); err != nil {
+			failf("Failed to set simulator appearance, error: %s", err)
+		}
+	}
+
+	if configs.OverrideStatusBar == "yes" {
+		fmt.Println()
+		log.Infof("Overriding simulator status bar")
+		if err := overrideStatusBar(sim); err != nil {
+			failf("Failed to override simulator status bar, error: %s", err)
+		}
+	}
+
+	if configs.SimulatorLatitude != "" && configs.SimulatorLongitude != "" {
+		fmt.Println()
+		log.Infof("Setting simulator location: %s, %s", configs.SimulatorLatitude, configs.SimulatorLongitude)
+		if err := setLocation(sim, configs.SimulatorLatitude, configs.SimulatorLongitude); err != nil {
+			failf("Failed to set simulator location, error: %s", err)
+		}
+	}
+}
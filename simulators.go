@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-tools/go-xcode/simulator"
+)
+
+// appWarmUpDuration is the time the app runs for during the warm-up.
+const appWarmUpDuration = 5 * time.Second
+
+// SimulatorModel ...
+type SimulatorModel struct {
+	Device    string
+	OsVersion string
+
+	Info simulator.InfoModel
+
+	// SnapshotDir holds the copy of the prepared simulator's data dir, if snapshot_simulator is enabled.
+	SnapshotDir string
+}
+
+// Name ...
+func (sim SimulatorModel) Name() string {
+	return fmt.Sprintf("%s (%s)", sim.Device, sim.OsVersion)
+}
+
+// getSimulators returns the simulators for every device - os version combination.
+func getSimulators(devices, osVersions string, createIfMissing bool) ([]SimulatorModel, error) {
+	simulators := []SimulatorModel{}
+
+	for _, osVersion := range splitList(osVersions) {
+		for _, device := range splitList(devices) {
+			info, err := getSimulatorInfo(osVersion, device)
+			if err != nil {
+				if !createIfMissing {
+					return nil, err
+				}
+
+				log.Warnf("%s, creating simulator...", err)
+				info, err = createSimulator(osVersion, device)
+				if err != nil {
+					return nil, fmt.Errorf("Failed to create simulator (%s, %s), error: %s", device, osVersion, err)
+				}
+				log.Donef("Simulator created, id: %s", info.ID)
+			}
+
+			simulators = append(simulators, SimulatorModel{
+				Device:    info.Name,
+				OsVersion: osVersion,
+				Info:      info,
+			})
+		}
+	}
+
+	return simulators, nil
+}
+
+// getSimulatorByUDID returns the simulator with the given UDID.
+func getSimulatorByUDID(udid string) (SimulatorModel, error) {
+	osVersionSimulatorInfosMap, err := getOsVersionSimulatorInfosMap()
+	if err != nil {
+		return SimulatorModel{}, err
+	}
+
+	for osVersion, infos := range osVersionSimulatorInfosMap {
+		for _, info := range infos {
+			if strings.EqualFold(info.ID, udid) {
+				return SimulatorModel{
+					Device:    info.Name,
+					OsVersion: osVersion,
+					Info:      info,
+				}, nil
+			}
+		}
+	}
+
+	return SimulatorModel{}, fmt.Errorf("No simulator found with UDID: %s", udid)
+}
+
+// DataDir is the simulator device's data directory.
+func (sim SimulatorModel) DataDir() string {
+	return filepath.Join(pathutil.UserHomeDir(), "Library", "Developer", "CoreSimulator", "Devices", sim.Info.ID, "data")
+}
+
+// simctlTimeout is the time limit of a simctl command, 0 means no limit.
+var simctlTimeout time.Duration
+
+// runSimctl runs the simctl command, and kills it if it does not finish within simctlTimeout.
+// If stdout is nil, the command's stdout is written into the combined output along with its stderr.
+func runSimctl(stdout io.Writer, args ...string) (string, error) {
+	ctx := context.Background()
+	if simctlTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, simctlTimeout)
+		defer cancel()
+	}
+
+	var combined bytes.Buffer
+	cmd := exec.CommandContext(ctx, "xcrun", append([]string{"simctl"}, args...)...)
+	cmd.Stdout = &combined
+	if stdout != nil {
+		cmd.Stdout = stdout
+	}
+	cmd.Stderr = &combined
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", simctlTimeout)
+	}
+	return strings.TrimSpace(combined.String()), err
+}
+
+func simctl(args ...string) (string, error) {
+	printableCmd := command.PrintableCommandArgs(false, append([]string{"xcrun", "simctl"}, args...))
+	log.Printf("$ %s", printableCmd)
+
+	out, err := runSimctl(nil, args...)
+	if err != nil {
+		return out, fmt.Errorf("%s failed, output: %s, error: %s", printableCmd, out, err)
+	}
+	return out, nil
+}
+
+// simctlList runs `simctl list --json` and decodes its output into v.
+func simctlList(v interface{}) error {
+	var stdout bytes.Buffer
+	if out, err := runSimctl(&stdout, "list", "--json"); err != nil {
+		return fmt.Errorf("simctl list failed, output: %s, error: %s", out, err)
+	}
+
+	if err := json.Unmarshal(stdout.Bytes(), v); err != nil {
+		return fmt.Errorf("Failed to parse simctl list output, error: %s", err)
+	}
+	return nil
+}
+
+// bootSimulatorWithRetry boots the simulator, retrying the boot up to attempts times,
+// the delay between the attempts is doubled after every attempt.
+func bootSimulatorWithRetry(sim *SimulatorModel, attempts int, delay time.Duration) error {
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = bootSimulator(sim); err == nil {
+			return nil
+		}
+		if attempt == attempts {
+			break
+		}
+
+		log.Warnf("Boot attempt %d/%d failed, error: %s", attempt, attempts, err)
+		sim.Info.Status = "Booted"
+		if shutdownErr := shutdownSimulator(sim); shutdownErr != nil {
+			log.Warnf("%s", shutdownErr)
+		}
+
+		log.Printf("Retrying in %s...", delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+	return err
+}
+
+// bootSimulator boots the simulator, waits for it to finish booting and checks if SpringBoard is running.
+func bootSimulator(sim *SimulatorModel) error {
+	if sim.Info.Status != "Booted" {
+		if out, err := simctl("boot", sim.Info.ID); err != nil && !strings.Contains(out, "current state: Booted") {
+			return fmt.Errorf("Failed to boot simulator (%s): %s", sim.Name(), err)
+		}
+		sim.Info.Status = "Booted"
+	}
+
+	if _, err := simctl("bootstatus", sim.Info.ID); err != nil {
+		return fmt.Errorf("Simulator (%s) did not finish booting: %s", sim.Name(), err)
+	}
+
+	out, err := simctl("spawn", sim.Info.ID, "launchctl", "list")
+	if err != nil {
+		return fmt.Errorf("Simulator (%s) is not responsive: %s", sim.Name(), err)
+	}
+	if !strings.Contains(out, "com.apple.SpringBoard") {
+		return fmt.Errorf("Simulator (%s) booted, but SpringBoard is not running", sim.Name())
+	}
+
+	return nil
+}
+
+// startVideoRecording starts recording the simulator's screen into the given .mp4 file.
+func startVideoRecording(sim SimulatorModel, pth string) (*BackgroundProcessModel, error) {
+	if err := os.RemoveAll(pth); err != nil {
+		return nil, fmt.Errorf("Failed to remove previous recording (%s), error: %s", pth, err)
+	}
+
+	return startBackgroundProcess(ioutil.Discard, "xcrun", "simctl", "io", sim.Info.ID, "recordVideo", pth)
+}
+
+// startSystemLogCapture streams the simulator's system log into the given file.
+func startSystemLogCapture(sim SimulatorModel, pth string) (*BackgroundProcessModel, error) {
+	return startBackgroundProcessWithOutputFile(pth, "xcrun", "simctl", "spawn", sim.Info.ID, "log", "stream", "--level", "debug", "--style", "compact")
+}
+
+// grantPermissions grants the given privacy services (photos, location, ...) to the app on the simulator.
+func grantPermissions(sim SimulatorModel, bundleID string, services []string) error {
+	for _, service := range services {
+		if _, err := simctl("privacy", sim.Info.ID, "grant", service, bundleID); err != nil {
+			return fmt.Errorf("Failed to grant %s permission: %s", service, err)
+		}
+	}
+	return nil
+}
+
+func installApp(sim SimulatorModel, appPth string) error {
+	if _, err := simctl("install", sim.Info.ID, appPth); err != nil {
+		return fmt.Errorf("Failed to install app (%s): %s", appPth, err)
+	}
+	return nil
+}
+
+// warmUpApp installs, launches and terminates the app,
+// so the first test does not have to wait for the cold start of the app and the simulator.
+func warmUpApp(sim SimulatorModel, appPth string) error {
+	bundleID, err := appBundleID(appPth)
+	if err != nil {
+		return err
+	}
+
+	if err := installApp(sim, appPth); err != nil {
+		return err
+	}
+
+	if _, err := simctl("launch", sim.Info.ID, bundleID); err != nil {
+		return fmt.Errorf("Failed to launch app (%s): %s", bundleID, err)
+	}
+	time.Sleep(appWarmUpDuration)
+
+	// terminate fails if the app already exited
+	if _, err := simctl("terminate", sim.Info.ID, bundleID); err != nil {
+		log.Warnf("Failed to terminate app (%s): %s", bundleID, err)
+	}
+	return nil
+}
+
+// uninstallApp removes the app from the simulator, so the test run installs it again.
+func uninstallApp(sim SimulatorModel, bundleID string) error {
+	_, err := simctl("uninstall", sim.Info.ID, bundleID)
+	return err
+}
+
+// pushNotifications delivers the given APNs payload files to the app on the booted simulator.
+func pushNotifications(sim SimulatorModel, bundleID string, payloadPths []string) error {
+	for _, payloadPth := range payloadPths {
+		if _, err := simctl("push", sim.Info.ID, bundleID, payloadPth); err != nil {
+			return fmt.Errorf("Failed to push payload (%s): %s", payloadPth, err)
+		}
+	}
+	return nil
+}
+
+func shutdownSimulator(sim *SimulatorModel) error {
+	if sim.Info.Status == "Shutdown" {
+		return nil
+	}
+
+	if out, err := simctl("shutdown", sim.Info.ID); err != nil && !strings.Contains(out, "current state: Shutdown") {
+		return fmt.Errorf("Failed to shut down simulator (%s): %s", sim.Name(), err)
+	}
+	sim.Info.Status = "Shutdown"
+
+	return nil
+}
+
+// eraseSimulator shuts down the simulator (if needed) and erases its content and settings.
+func eraseSimulator(sim *SimulatorModel) error {
+	if err := shutdownSimulator(sim); err != nil {
+		return err
+	}
+
+	_, err := simctl("erase", sim.Info.ID)
+	return err
+}
+
+func writeDefaults(plistPth string, args ...string) error {
+	cmd := command.New("defaults", append([]string{"write", plistPth}, args...)...)
+	log.Printf("$ %s", cmd.PrintableCommandArgs())
+
+	if out, err := cmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed, output: %s, error: %s", cmd.PrintableCommandArgs(), out, err)
+	}
+	return nil
+}
+
+// disconnectHardwareKeyboard turns off the Simulator app's ConnectHardwareKeyboard preference,
+// both the global one and the one of the given simulator (used by newer Xcode versions).
+// The preference takes effect on the next simulator boot.
+func disconnectHardwareKeyboard(sim SimulatorModel) error {
+	if err := writeDefaults("com.apple.iphonesimulator", "ConnectHardwareKeyboard", "-bool", "false"); err != nil {
+		return err
+	}
+
+	return writeDefaults("com.apple.iphonesimulator", "DevicePreferences", "-dict-add", sim.Info.ID, "{ ConnectHardwareKeyboard = 0; }")
+}
+
+// setSimulatorLanguageAndLocale writes AppleLanguages and AppleLocale into the simulator's global preferences.
+// The simulator is shut down first, the preferences take effect on the next boot.
+func setSimulatorLanguageAndLocale(sim *SimulatorModel, language, locale string) error {
+	if err := shutdownSimulator(sim); err != nil {
+		return err
+	}
+
+	globalPreferencesPth := filepath.Join(sim.DataDir(), "Library", "Preferences", ".GlobalPreferences.plist")
+
+	if language != "" {
+		if err := writeDefaults(globalPreferencesPth, "AppleLanguages", "-array", language); err != nil {
+			return err
+		}
+	}
+	if locale != "" {
+		if err := writeDefaults(globalPreferencesPth, "AppleLocale", "-string", locale); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setSimulatorTimezone sets the TZ environment for the processes launched on the booted simulator,
+// and for the processes launched by simctl from the step's child processes.
+func setSimulatorTimezone(sim SimulatorModel, timezone string) error {
+	if _, err := simctl("spawn", sim.Info.ID, "launchctl", "setenv", "TZ", timezone); err != nil {
+		return err
+	}
+
+	return os.Setenv("SIMCTL_CHILD_TZ", timezone)
+}
+
+// setInterfaceStyle sets the appearance (light or dark) of the booted simulator.
+func setInterfaceStyle(sim SimulatorModel, style string) error {
+	_, err := simctl("ui", sim.Info.ID, "appearance", style)
+	return err
+}
+
+// overrideStatusBar sets a clean status bar on the booted simulator (9:41, full battery and signal),
+// so the screenshots taken during the tests do not depend on the actual time and network state.
+func overrideStatusBar(sim SimulatorModel) error {
+	_, err := simctl("status_bar", sim.Info.ID, "override",
+		"--time", "9:41",
+		"--dataNetwork", "wifi",
+		"--wifiMode", "active",
+		"--wifiBars", "3",
+		"--cellularMode", "active",
+		"--cellularBars", "4",
+		"--batteryState", "charged",
+		"--batteryLevel", "100")
+	return err
+}
+
+func clearStatusBar(sim SimulatorModel) error {
+	_, err := simctl("status_bar", sim.Info.ID, "clear")
+	return err
+}
+
+// setLocation sets the simulated GPS location of the booted simulator.
+func setLocation(sim SimulatorModel, latitude, longitude string) error {
+	_, err := simctl("location", sim.Info.ID, "set", latitude+","+longitude)
+	return err
+}
+
+// staleSimulatorProcessNames are the processes left behind by the simulators of previous builds.
+var staleSimulatorProcessNames = []string{"Simulator", "com.apple.CoreSimulator.CoreSimulatorService", "DTServiceHub"}
+
+// cleanupStaleSimulators shuts down every simulator and kills the leftover simulator processes,
+// it returns the killed processes in `<pid> <name>` format.
+func cleanupStaleSimulators() ([]string, error) {
+	if _, err := simctl("shutdown", "all"); err != nil {
+		return nil, err
+	}
+
+	killed := []string{}
+	for _, name := range staleSimulatorProcessNames {
+		// pgrep exits with 1 if no process matched
+		out, err := command.New("pgrep", "-l", "-x", name).RunAndReturnTrimmedCombinedOutput()
+		if err != nil || out == "" {
+			continue
+		}
+
+		if out, err := command.New("pkill", "-9", "-x", name).RunAndReturnTrimmedCombinedOutput(); err != nil {
+			return killed, fmt.Errorf("Failed to kill %s, output: %s, error: %s", name, out, err)
+		}
+		killed = append(killed, strings.Split(out, "\n")...)
+	}
+
+	return killed, nil
+}
+
+// snapshotSimulatorData shuts down the simulator and copies its data dir into a temporary dir.
+func snapshotSimulatorData(sim *SimulatorModel) error {
+	if err := shutdownSimulator(sim); err != nil {
+		return err
+	}
+
+	snapshotDir, err := pathutil.NormalizedOSTempDirPath("simulator-snapshot")
+	if err != nil {
+		return fmt.Errorf("Failed to create snapshot dir, error: %s", err)
+	}
+
+	if out, err := command.New("rsync", "-a", "--delete", sim.DataDir()+"/", snapshotDir+"/").RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("Failed to copy simulator data, output: %s, error: %s", out, err)
+	}
+	sim.SnapshotDir = snapshotDir
+
+	return nil
+}
+
+// restoreSimulatorData shuts down the simulator and replaces its data dir with the snapshot.
+func restoreSimulatorData(sim *SimulatorModel) error {
+	if err := shutdownSimulator(sim); err != nil {
+		return err
+	}
+
+	if out, err := command.New("rsync", "-a", "--delete", sim.SnapshotDir+"/", sim.DataDir()+"/").RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("Failed to restore simulator data, output: %s, error: %s", out, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-tools/go-xamarin/constants"
+)
+
+func isGlobPattern(pth string) bool {
+	return strings.ContainsAny(pth, "*?[")
+}
+
+// globToRegexp converts a glob pattern to a regexp,
+// `**/` matches any number of directories, `*` and `?` do not match the path separator.
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	pattern = filepath.ToSlash(filepath.Clean(pattern))
+
+	expStr := "^"
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			expStr += "(.*/)?"
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			expStr += ".*"
+			i++
+		case c == '*':
+			expStr += "[^/]*"
+		case c == '?':
+			expStr += "[^/]"
+		case c == '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated character class in pattern: %s", pattern)
+			}
+			expStr += pattern[i : i+end+1]
+			i += end
+		default:
+			expStr += regexp.QuoteMeta(string(c))
+		}
+	}
+	expStr += "$"
+
+	return regexp.Compile(expStr)
+}
+
+// globBaseDir returns the longest leading directory of the pattern, which does not contain glob characters.
+func globBaseDir(pattern string) string {
+	components := strings.Split(filepath.ToSlash(filepath.Clean(pattern)), "/")
+
+	baseComponents := []string{}
+	for _, component := range components {
+		if isGlobPattern(component) {
+			break
+		}
+		baseComponents = append(baseComponents, component)
+	}
+
+	if len(baseComponents) == 0 {
+		return "."
+	}
+	if len(baseComponents) == 1 && baseComponents[0] == "" {
+		return "/"
+	}
+	return filepath.FromSlash(strings.Join(baseComponents, "/"))
+}
+
+func findFiles(rootDir string, match func(pth string) bool) ([]string, error) {
+	pths := []string{}
+
+	if err := filepath.Walk(rootDir, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			name := info.Name()
+			if pth != rootDir && (name == ".git" || name == "node_modules" || name == "bin" || name == "obj") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if match(pth) {
+			pths = append(pths, pth)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(pths)
+	return pths, nil
+}
+
+func findSolutions(pth string) ([]string, error) {
+	if isGlobPattern(pth) {
+		exp, err := globToRegexp(pth)
+		if err != nil {
+			return nil, err
+		}
+
+		baseDir := globBaseDir(pth)
+		return findFiles(baseDir, func(filePth string) bool {
+			return exp.MatchString(filepath.ToSlash(filepath.Clean(filePth)))
+		})
+	}
+
+	return findFiles(pth, func(filePth string) bool {
+		return filepath.Ext(filePth) == constants.SolutionExt
+	})
+}
+
+// resolveSolutionPath returns the solution to test.
+// If pth is a directory or a glob pattern, it searches for the solutions and
+// picks the one containing Xamarin.UITest projects, the solutions failing to analyze are skipped.
+func resolveSolutionPath(pth string) (string, error) {
+	if !isGlobPattern(pth) {
+		if exist, err := pathutil.IsDirExists(pth); err != nil {
+			return "", fmt.Errorf("Failed to check if dir (%s) exist, error: %s", pth, err)
+		} else if !exist {
+			return pth, nil
+		}
+	}
+
+	solutionPths, err := findSolutions(pth)
+	if err != nil {
+		return "", fmt.Errorf("Failed to search for solutions (%s), error: %s", pth, err)
+	}
+	if len(solutionPths) == 0 {
+		return "", fmt.Errorf("No solution found for: %s", pth)
+	}
+
+	candidates := []string{}
+	for _, solutionPth := range solutionPths {
+		testProjectNames, err := xamarinUITestProjectNames(solutionPth)
+		if err != nil {
+			log.Warnf("%s, skipping...", err)
+			continue
+		}
+		if len(testProjectNames) > 0 {
+			candidates = append(candidates, solutionPth)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("None of the solutions contains Xamarin.UITest project:\n%s", strings.Join(solutionPths, "\n"))
+	case 1:
+		return candidates[0], nil
+	default:
+		return "", fmt.Errorf("Multiple solutions contain Xamarin.UITest project, set xamarin_project to one of them:\n%s", strings.Join(candidates, "\n"))
+	}
+}
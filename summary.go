@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+)
+
+// markdownFailedTestsLimit is the maximum number of failed tests listed in the markdown summary.
+const markdownFailedTestsLimit = 50
+
+func formatDuration(seconds float64) string {
+	return time.Duration(seconds * float64(time.Second)).Round(time.Millisecond).String()
+}
+
+// summaryTable returns the test counts and the duration of the test runs as a table, with a total row at the end.
+func summaryTable(testRuns []TestRunModel) string {
+	var buf bytes.Buffer
+	writer := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(writer, "TEST RUN\tSTATUS\tTOTAL\tPASSED\tFAILED\tSKIPPED\tDURATION")
+	for _, testRun := range testRuns {
+		status := "succeeded"
+		if testRun.Err != nil {
+			status = "failed"
+		}
+
+		if testRun.Results == nil {
+			fmt.Fprintf(writer, "%s\t%s\t-\t-\t-\t-\t-\n", testRun.FullName(), status)
+			continue
+		}
+
+		results := testRun.Results
+		fmt.Fprintf(writer, "%s\t%s\t%d\t%d\t%d\t%d\t%s\n", testRun.FullName(), status,
+			results.Total, results.Passed, results.Failed, results.Skipped+results.Inconclusive, formatDuration(results.Duration))
+	}
+
+	counts := testCounts(testRuns)
+	fmt.Fprintf(writer, "TOTAL\t\t%d\t%d\t%d\t%d\t%s\n",
+		counts.Total, counts.Passed, counts.Failed, counts.Skipped+counts.Inconclusive, formatDuration(counts.Duration))
+
+	if err := writer.Flush(); err != nil {
+		return ""
+	}
+	return buf.String()
+}
+
+var markdownCellEscaper = strings.NewReplacer("|", "\\|", "\r", "", "\n", " ")
+
+func markdownCell(value string) string {
+	return strings.TrimSpace(markdownCellEscaper.Replace(value))
+}
+
+// markdownSummary returns the test counts, durations and the failed tests of the test runs as GitHub-flavored markdown.
+// The references to the screenshots and attachments in the failure messages point to the copies in the deploy dir.
+func markdownSummary(testRuns []TestRunModel, deployDir string) string {
+	var buf bytes.Buffer
+
+	counts := testCounts(testRuns)
+	status := "succeeded"
+	for _, testRun := range testRuns {
+		if testRun.Err != nil {
+			status = "failed"
+		}
+	}
+
+	fmt.Fprintf(&buf, "### Xamarin UITest %s\n\n", status)
+	fmt.Fprintf(&buf, "%d passed, %d failed, %d skipped, %d total in %s\n\n",
+		counts.Passed, counts.Failed, counts.Skipped+counts.Inconclusive, counts.Total, formatDuration(counts.Duration))
+
+	buf.WriteString("| Test run | Status | Total | Passed | Failed | Skipped | Duration |\n")
+	buf.WriteString("| --- | --- | ---: | ---: | ---: | ---: | ---: |\n")
+	for _, testRun := range testRuns {
+		runStatus := "succeeded"
+		if testRun.Err != nil {
+			runStatus = "failed"
+		}
+
+		if testRun.Results == nil {
+			fmt.Fprintf(&buf, "| %s | %s | - | - | - | - | - |\n", markdownCell(testRun.FullName()), runStatus)
+			continue
+		}
+
+		results := testRun.Results
+		fmt.Fprintf(&buf, "| %s | %s | %d | %d | %d | %d | %s |\n", markdownCell(testRun.FullName()), runStatus,
+			results.Total, results.Passed, results.Failed, results.Skipped+results.Inconclusive, formatDuration(results.Duration))
+	}
+
+	type failedTest struct {
+		testRun  TestRunModel
+		testCase TestCaseModel
+	}
+	failedTests := []failedTest{}
+	for _, testRun := range testRuns {
+		for _, testCase := range failedTestCases(testRun) {
+			failedTests = append(failedTests, failedTest{testRun: testRun, testCase: testCase})
+		}
+	}
+
+	if len(failedTests) > 0 {
+		buf.WriteString("\n#### Failed tests\n\n")
+		buf.WriteString("| Test | Test run | Message |\n")
+		buf.WriteString("| --- | --- | --- |\n")
+		for i, failed := range failedTests {
+			if i == markdownFailedTestsLimit {
+				fmt.Fprintf(&buf, "\n... and %d more failed test(s)\n", len(failedTests)-markdownFailedTestsLimit)
+				break
+			}
+
+			message := ""
+			if failed.testCase.Failure != nil {
+				message = strings.SplitN(strings.TrimSpace(failed.testCase.Failure.Message), "\n", 2)[0]
+				message = rewriteArtifactReferences(message, failed.testRun.DeployedFiles, deployDir)
+			}
+			fmt.Fprintf(&buf, "| %s | %s | %s |\n", markdownCell(failed.testCase.FullName), markdownCell(failed.testRun.FullName()), markdownCell(message))
+		}
+	}
+
+	return buf.String()
+}
+
+// SummarySimulatorModel ...
+type SummarySimulatorModel struct {
+	Name      string `json:"name"`
+	UDID      string `json:"udid"`
+	OsVersion string `json:"os_version"`
+}
+
+// SummaryTestRunModel ...
+type SummaryTestRunModel struct {
+	TestProject  string                `json:"test_project"`
+	Project      string                `json:"project"`
+	Simulator    SummarySimulatorModel `json:"simulator"`
+	Iteration    int                   `json:"iteration,omitempty"`
+	Status       string                `json:"status"`
+	Error        string                `json:"error,omitempty"`
+	Total        int                   `json:"total"`
+	Passed       int                   `json:"passed"`
+	Failed       int                   `json:"failed"`
+	Skipped      int                   `json:"skipped"`
+	Duration     float64               `json:"duration"`
+	FailedTests  []string              `json:"failed_tests"`
+	FlakyTests   []string              `json:"flaky_tests"`
+	ResultLogPth string                `json:"result_path"`
+}
+
+// SummaryModel ...
+type SummaryModel struct {
+	Status   string                `json:"status"`
+	Total    int                   `json:"total"`
+	Passed   int                   `json:"passed"`
+	Failed   int                   `json:"failed"`
+	Skipped  int                   `json:"skipped"`
+	Duration float64               `json:"duration"`
+	TestRuns []SummaryTestRunModel `json:"test_runs"`
+}
+
+// jsonSummary returns the machine-readable summary of the test runs.
+func jsonSummary(testRuns []TestRunModel) SummaryModel {
+	counts := testCounts(testRuns)
+	summary := SummaryModel{
+		Status:   "succeeded",
+		Total:    counts.Total,
+		Passed:   counts.Passed,
+		Failed:   counts.Failed,
+		Skipped:  counts.Skipped + counts.Inconclusive,
+		Duration: counts.Duration,
+		TestRuns: []SummaryTestRunModel{},
+	}
+
+	for _, testRun := range testRuns {
+		run := SummaryTestRunModel{
+			TestProject: testRun.TestProjectName,
+			Project:     testRun.ProjectName,
+			Simulator: SummarySimulatorModel{
+				Name:      testRun.Simulator.Name(),
+				UDID:      testRun.Simulator.Info.ID,
+				OsVersion: testRun.Simulator.OsVersion,
+			},
+			Iteration:    testRun.Iteration,
+			Status:       "succeeded",
+			FailedTests:  []string{},
+			FlakyTests:   testRun.FlakyTests,
+			ResultLogPth: testRun.ResultLogPth,
+		}
+		if run.FlakyTests == nil {
+			run.FlakyTests = []string{}
+		}
+		if testRun.Err != nil {
+			run.Status = "failed"
+			run.Error = maskSecrets(testRun.Err.Error())
+			summary.Status = testResult(testRuns)
+		}
+		if results := testRun.Results; results != nil {
+			run.Total = results.Total
+			run.Passed = results.Passed
+			run.Failed = results.Failed
+			run.Skipped = results.Skipped + results.Inconclusive
+			run.Duration = results.Duration
+		}
+		for _, testCase := range failedTestCases(testRun) {
+			run.FailedTests = append(run.FailedTests, testCase.FullName)
+		}
+
+		summary.TestRuns = append(summary.TestRuns, run)
+	}
+
+	return summary
+}
+
+func writeJSONSummary(pth string, testRuns []TestRunModel) error {
+	content, err := json.MarshalIndent(jsonSummary(testRuns), "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to serialize test summary, error: %s", err)
+	}
+
+	return fileutil.WriteBytesToFile(pth, content)
+}
+
+// writeTimingCSV writes the duration and the result of every test case of the test runs into a CSV file.
+func writeTimingCSV(pth string, testRuns []TestRunModel) error {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+
+	if err := writer.Write([]string{"test", "fixture", "duration", "outcome", "test_run", "simulator"}); err != nil {
+		return err
+	}
+	for _, testRun := range testRuns {
+		if testRun.Results == nil {
+			continue
+		}
+		for _, testCase := range testRun.Results.TestCases {
+			record := []string{
+				testCase.FullName,
+				testCase.Fixture(),
+				fmt.Sprintf("%.3f", testCase.Duration),
+				testCase.Result,
+				testRun.Name(),
+				testRun.Simulator.Name(),
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("Failed to serialize test timings, error: %s", err)
+	}
+
+	return fileutil.WriteBytesToFile(pth, buf.Bytes())
+}
@@ -0,0 +1,691 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-tools/go-xamarin/builder"
+)
+
+// TestRunModel ...
+type TestRunModel struct {
+	TestProjectName string
+	// TestProjectNames holds the names of the test projects of the test run, more if the test assemblies are combined.
+	TestProjectNames []string
+	ProjectName      string
+	Simulator        SimulatorModel
+
+	// Iteration is the number of the repeated test run (repeat_count), 0 if the tests are not repeated.
+	Iteration int
+
+	ResultLogPth string
+	Results      *TestResultsModel
+	FlakyTests   []string
+	// DeployedFiles holds the screenshots and test attachments copied into the deploy dir, by their original paths.
+	DeployedFiles map[string]string
+	// EvidencePths holds the NUnit trace logs and crash reports copied into the deploy dir.
+	EvidencePths []string
+	// SystemLogPth is the captured simulator system log, if capture_simulator_log is enabled.
+	SystemLogPth string
+
+	Err error
+}
+
+// Name ...
+func (run TestRunModel) Name() string {
+	return fmt.Sprintf("%s - %s", run.TestProjectName, run.ProjectName)
+}
+
+// FullName is the name of the test run including the simulator's name (and the number of the repeated test run).
+func (run TestRunModel) FullName() string {
+	if run.Iteration > 0 {
+		return fmt.Sprintf("%s - %s - run %d", run.Name(), run.Simulator.Name(), run.Iteration)
+	}
+	return fmt.Sprintf("%s - %s", run.Name(), run.Simulator.Name())
+}
+
+// testCounts sums up the test counts of the test runs.
+func testCounts(testRuns []TestRunModel) TestResultsModel {
+	counts := TestResultsModel{}
+	for _, testRun := range testRuns {
+		if testRun.Results == nil {
+			continue
+		}
+		counts.Total += testRun.Results.Total
+		counts.Passed += testRun.Results.Passed
+		counts.Failed += testRun.Results.Failed
+		counts.Inconclusive += testRun.Results.Inconclusive
+		counts.Skipped += testRun.Results.Skipped
+		counts.Duration += testRun.Results.Duration
+	}
+	return counts
+}
+
+// failedTestCases returns the test cases of the failed test run, which are still failing after the retries.
+func failedTestCases(testRun TestRunModel) []TestCaseModel {
+	if testRun.Err == nil || testRun.Results == nil {
+		return nil
+	}
+
+	flaky := map[string]bool{}
+	for _, name := range testRun.FlakyTests {
+		flaky[name] = true
+	}
+
+	failed := []TestCaseModel{}
+	for _, testCase := range testRun.Results.FailedTestCases() {
+		if !flaky[testCase.FullName] {
+			failed = append(failed, testCase)
+		}
+	}
+	return failed
+}
+
+// testProjectStatuses returns the status of every tested test project, in the order of the test runs:
+// failed if any of its test runs failed, succeeded otherwise.
+func testProjectStatuses(testRuns []TestRunModel) ([]string, map[string]string) {
+	names := []string{}
+	statuses := map[string]string{}
+	for _, testRun := range testRuns {
+		for _, name := range testRun.TestProjectNames {
+			if _, ok := statuses[name]; !ok {
+				names = append(names, name)
+				statuses[name] = "succeeded"
+			}
+			if testRun.Err != nil {
+				statuses[name] = "failed"
+			}
+		}
+	}
+	return names, statuses
+}
+
+// testResult returns the overall result of the failed tests: partially_failed if some of the test projects succeeded.
+func testResult(testRuns []TestRunModel) string {
+	names, statuses := testProjectStatuses(testRuns)
+	for _, name := range names {
+		if statuses[name] == "succeeded" {
+			return "partially_failed"
+		}
+	}
+	return "failed"
+}
+
+var envKeyInvalidCharsPattern = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// testProjectResultEnvKey returns the key of the test project's result env: BITRISE_XAMARIN_TEST_RESULT_<TEST PROJECT>,
+// the test project name is upper-cased and the characters not allowed in env keys are replaced with underscores.
+func testProjectResultEnvKey(testProjectName string) string {
+	name := strings.Trim(envKeyInvalidCharsPattern.ReplaceAllString(strings.ToUpper(testProjectName), "_"), "_")
+	return "BITRISE_XAMARIN_TEST_RESULT_" + name
+}
+
+// slowTests returns the tests running longer than the threshold (in seconds), the slowest first,
+// in "<test> (<duration>, <test run>)" format.
+func slowTests(testRuns []TestRunModel, threshold float64) []string {
+	type slowTest struct {
+		name     string
+		testRun  string
+		duration float64
+	}
+	slow := []slowTest{}
+	for _, testRun := range testRuns {
+		if testRun.Results == nil {
+			continue
+		}
+		for _, testCase := range testRun.Results.TestCases {
+			if testCase.Duration > threshold {
+				slow = append(slow, slowTest{name: testCase.FullName, testRun: testRun.FullName(), duration: testCase.Duration})
+			}
+		}
+	}
+
+	sort.SliceStable(slow, func(i, j int) bool {
+		return slow[i].duration > slow[j].duration
+	})
+
+	tests := []string{}
+	for _, test := range slow {
+		tests = append(tests, fmt.Sprintf("%s (%s, %s)", test.name, formatDuration(test.duration), test.testRun))
+	}
+	return tests
+}
+
+// onlyTestFailures returns whether every failed test run failed because of failing tests,
+// and not because of a runner error (e.g. a crash or a missing result xml).
+func onlyTestFailures(testRuns []TestRunModel) bool {
+	for _, testRun := range testRuns {
+		if testRun.Err != nil && len(failedTestCases(testRun)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// uniqueFailedTestNames returns the full names of the tests still failing in any of the test runs, without duplicates.
+func uniqueFailedTestNames(testRuns []TestRunModel) []string {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, testRun := range testRuns {
+		for _, testCase := range failedTestCases(testRun) {
+			if !seen[testCase.FullName] {
+				seen[testCase.FullName] = true
+				names = append(names, testCase.FullName)
+			}
+		}
+	}
+	return names
+}
+
+const errorMessageLineLength = 200
+
+// errorMessageDigest returns a short digest of the failed tests, one line per failure: `<test>: <first line of the failure message>`,
+// the failed test runs without failing tests are listed with their error.
+// Only the first limit failures are listed, limit 0 lists every failure.
+func errorMessageDigest(testRuns []TestRunModel, limit int) string {
+	lines := []string{}
+	seen := map[string]bool{}
+	for _, testRun := range testRuns {
+		if testRun.Err == nil {
+			continue
+		}
+
+		failed := failedTestCases(testRun)
+		if len(failed) == 0 {
+			lines = append(lines, fmt.Sprintf("%s: %s", testRun.FullName(), testRun.Err))
+			continue
+		}
+
+		for _, testCase := range failed {
+			if seen[testCase.FullName] {
+				continue
+			}
+			seen[testCase.FullName] = true
+
+			line := testCase.FullName
+			if testCase.Failure != nil {
+				if message := strings.TrimSpace(testCase.Failure.Message); message != "" {
+					line += ": " + strings.TrimSpace(strings.SplitN(message, "\n", 2)[0])
+				}
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	for i, line := range lines {
+		if runes := []rune(line); len(runes) > errorMessageLineLength {
+			lines[i] = string(runes[:errorMessageLineLength-3]) + "..."
+		}
+	}
+
+	if limit > 0 && len(lines) > limit {
+		more := len(lines) - limit
+		lines = append(lines[:limit], fmt.Sprintf("... and %d more", more))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// unstableTests returns the tests which failed in some, but not in all of the repeated test runs,
+// in `<test> (failed <n>/<runs> runs)` format.
+func unstableTests(testRuns []TestRunModel) []string {
+	runCount := map[string]int{}
+	failCount := map[string]int{}
+	names := []string{}
+
+	for _, testRun := range testRuns {
+		if testRun.Results == nil {
+			continue
+		}
+		for _, testCase := range testRun.Results.TestCases {
+			key := testRun.Name() + ": " + testCase.FullName
+			if _, ok := runCount[key]; !ok {
+				names = append(names, key)
+			}
+			runCount[key]++
+			if testCase.Result == testResultFailed {
+				failCount[key]++
+			}
+		}
+	}
+
+	unstable := []string{}
+	for _, name := range names {
+		if failCount[name] > 0 && failCount[name] < runCount[name] {
+			unstable = append(unstable, fmt.Sprintf("%s (failed %d/%d runs)", name, failCount[name], runCount[name]))
+		}
+	}
+	return unstable
+}
+
+// osVersionStatuses returns the status (succeeded or failed) of the test runs per simulator os version,
+// in `<os version>: <status>` format, in the order of the simulators.
+func osVersionStatuses(simulators []SimulatorModel, testRuns []TestRunModel) []string {
+	osVersions := []string{}
+	failed := map[string]bool{}
+	for _, sim := range simulators {
+		if _, ok := failed[sim.OsVersion]; !ok {
+			osVersions = append(osVersions, sim.OsVersion)
+			failed[sim.OsVersion] = false
+		}
+	}
+
+	for _, testRun := range testRuns {
+		if testRun.Err != nil {
+			failed[testRun.Simulator.OsVersion] = true
+		}
+	}
+
+	statuses := []string{}
+	for _, osVersion := range osVersions {
+		status := "succeeded"
+		if failed[osVersion] {
+			status = "failed"
+		}
+		statuses = append(statuses, fmt.Sprintf("%s: %s", osVersion, status))
+	}
+	return statuses
+}
+
+const (
+	testOrderDefault = "default"
+	testOrderShuffle = "shuffle"
+)
+
+// orderedTestProjectNames returns the names of the test projects sorted,
+// or shuffled with the given seed.
+func orderedTestProjectNames(testProjectOutputMap builder.TestProjectOutputMap, shuffle bool, seed int64) []string {
+	names := []string{}
+	for name := range testProjectOutputMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if shuffle {
+		shuffleStrings(names, rand.New(rand.NewSource(seed)))
+	}
+	return names
+}
+
+func shuffleStrings(items []string, random *rand.Rand) {
+	for i := len(items) - 1; i > 0; i-- {
+		j := random.Intn(i + 1)
+		items[i], items[j] = items[j], items[i]
+	}
+}
+
+// runnerOutputDirs returns the dirs the test runner writes its files into, besides the test assemblies' dirs:
+// the current dir and the nunit_work_dir.
+func runnerOutputDirs(configs ConfigsModel) []string {
+	dirs := []string{"."}
+	if configs.NunitWorkDir != "" {
+		dirs = append(dirs, configs.NunitWorkDir)
+	}
+	return dirs
+}
+
+// testJobModel is a test runner invocation: the test assemblies of the test projects tested against an app.
+type testJobModel struct {
+	TestProjectNames []string
+	DLLPths          []string
+	ProjectName      string
+	ProjectOutput    builder.ProjectOutputModel
+}
+
+// Dirs returns the dirs of the test assemblies.
+func (job testJobModel) Dirs() []string {
+	dirs := []string{}
+	for _, dllPth := range job.DLLPths {
+		dirs = append(dirs, filepath.Dir(dllPth))
+	}
+	return dirs
+}
+
+// testJobs returns the test runner invocations of the test pass, in test order:
+// every test project against every app it refers to,
+// or with combine_test_assemblies every app against the test projects referring to it,
+// if all of them run with nunit3-console.
+func testJobs(configs ConfigsModel, runners *TestRunnersModel, testProjectOutputMap builder.TestProjectOutputMap, projectOutputMap builder.ProjectOutputMap) []testJobModel {
+	shuffle := configs.TestOrder == testOrderShuffle
+	seed, _ := strconv.ParseInt(configs.TestSeed, 10, 64)
+
+	jobs := []testJobModel{}
+	jobIdxByProject := map[string]int{}
+
+	for _, testProjectName := range orderedTestProjectNames(testProjectOutputMap, shuffle, seed) {
+		testProjectOutput := testProjectOutputMap[testProjectName]
+		if len(testProjectOutput.ReferredProjectNames) == 0 {
+			log.Warnf("Test project (%s) does not refers to any project, skipping...", testProjectName)
+			continue
+		}
+
+		combine := false
+		if configs.CombineTestAssemblies == "yes" {
+			runner, err := runners.ForTestProject(testProjectName, testProjectOutput.Output.Pth)
+			if err != nil {
+				failf("Failed to select test runner, error: %s", err)
+			}
+			if _, ok := runner.(nunit3Runner); ok {
+				combine = true
+			} else {
+				log.Warnf("Test project (%s) does not run with nunit3-console, running it separately...", testProjectName)
+			}
+		}
+
+		projectNames := append([]string{}, testProjectOutput.ReferredProjectNames...)
+		if shuffle {
+			shuffleStrings(projectNames, rand.New(rand.NewSource(seed)))
+		}
+
+		for _, projectName := range projectNames {
+			projectOutput, ok := projectOutputMap[projectName]
+			if !ok {
+				continue
+			}
+
+			if idx, ok := jobIdxByProject[projectName]; ok && combine {
+				jobs[idx].TestProjectNames = append(jobs[idx].TestProjectNames, testProjectName)
+				jobs[idx].DLLPths = append(jobs[idx].DLLPths, testProjectOutput.Output.Pth)
+				continue
+			}
+
+			if combine {
+				jobIdxByProject[projectName] = len(jobs)
+			}
+			jobs = append(jobs, testJobModel{
+				TestProjectNames: []string{testProjectName},
+				DLLPths:          []string{testProjectOutput.Output.Pth},
+				ProjectName:      projectName,
+				ProjectOutput:    projectOutput,
+			})
+		}
+	}
+
+	return jobs
+}
+
+// runTestPass runs every test project against the apps it refers to, on the given simulator.
+// The results of every test run are written into a dedicated file in the deploy dir:
+// <test project>-<app project>-TestResult<resultLogSuffix>.xml.
+// It stops at the first failing test run, unless continue_on_failure is set.
+func runTestPass(configs ConfigsModel, runners *TestRunnersModel, sim SimulatorModel, iteration int, resultLogSuffix string, testProjectOutputMap builder.TestProjectOutputMap, projectOutputMap builder.ProjectOutputMap) []TestRunModel {
+	testRuns := []TestRunModel{}
+	retryFailedTestsCount, _ := parseNonNegativeInt(configs.RetryFailedTestsCount)
+	stackTraceLineLimit, _ := parseNonNegativeInt(configs.StackTraceLineLimit)
+
+	if err := os.Setenv("IOS_SIMULATOR_UDID", sim.Info.ID); err != nil {
+		failf("Failed to export simulator UDID, error: %s", err)
+	}
+
+	for _, job := range testJobs(configs, runners, testProjectOutputMap, projectOutputMap) {
+		projectName := job.ProjectName
+		testProjectName := strings.Join(job.TestProjectNames, ", ")
+
+		appPth := projectAppPath(job.ProjectOutput)
+		if appPth == "" {
+			failf("No app generated for project: %s", projectName)
+		}
+
+		resultLogPth := filepath.Join(configs.DeployDir, fmt.Sprintf("%s-%s-TestResult%s.xml", sanitizedFileName(strings.Join(job.TestProjectNames, "-")), sanitizedFileName(projectName), resultLogSuffix))
+
+		testRun := TestRunModel{
+			TestProjectName:  testProjectName,
+			TestProjectNames: job.TestProjectNames,
+			ProjectName:      projectName,
+			Simulator:        sim,
+			Iteration:        iteration,
+			ResultLogPth:     resultLogPth,
+			DeployedFiles:    map[string]string{},
+		}
+
+		if sim.SnapshotDir != "" && len(testRuns) > 0 {
+			restoreSimulator(configs, &sim)
+		}
+
+		// Set APP_BUNDLE_PATH env to let the test know which .app file should be tested
+		// This env is used in the Xamarin.UITest project to refer to the .app path
+		if err := os.Setenv("APP_BUNDLE_PATH", appPth); err != nil {
+			failf("Failed to set APP_BUNDLE_PATH environment, without this env test will fail, error: %s", err)
+		}
+
+		// Run test
+		fmt.Println()
+		log.Infof("Testing (%s) against (%s)", testProjectName, projectName)
+		for _, dllPth := range job.DLLPths {
+			log.Printf("test dll: %s", dllPth)
+		}
+		log.Printf("app: %s", appPth)
+		log.Printf("simulator: %s", sim.Name())
+
+		permissions := splitList(configs.GrantPermissions)
+		pushPayloads := splitList(configs.PushPayloads)
+
+		bundleID := ""
+		if len(permissions) > 0 || len(pushPayloads) > 0 || configs.ExportAppDataContainer == "yes" || configs.ReinstallApp == "yes" {
+			id, err := appBundleID(appPth)
+			if err != nil {
+				failf("Failed to determine the app's bundle id, error: %s", err)
+			}
+			bundleID = id
+		}
+
+		if configs.ReinstallApp == "yes" {
+			fmt.Println()
+			log.Infof("Uninstalling app: %s", bundleID)
+			if err := uninstallApp(sim, bundleID); err != nil {
+				failf("Failed to uninstall app, error: %s", err)
+			}
+		}
+
+		if len(permissions) > 0 {
+			fmt.Println()
+			log.Infof("Granting permissions: %s", strings.Join(permissions, ", "))
+
+			if err := grantPermissions(sim, bundleID, permissions); err != nil {
+				failf("Failed to grant permissions, error: %s", err)
+			}
+		}
+
+		if len(pushPayloads) > 0 {
+			fmt.Println()
+			log.Infof("Sending push notifications")
+
+			if err := pushNotifications(sim, bundleID, pushPayloads); err != nil {
+				failf("Failed to send push notifications, error: %s", err)
+			}
+		}
+
+		var videoRecording *BackgroundProcessModel
+		if configs.RecordVideo == "yes" {
+			videoPth := filepath.Join(configs.DeployDir, sanitizedFileName(testRun.FullName())+".mp4")
+
+			fmt.Println()
+			log.Infof("Recording simulator video: %s", videoPth)
+			recording, err := startVideoRecording(sim, videoPth)
+			if err != nil {
+				log.Warnf("Failed to start video recording, error: %s", err)
+			} else {
+				videoRecording = recording
+			}
+		}
+
+		var systemLogCapture *BackgroundProcessModel
+		if configs.CaptureSimulatorLog == "yes" {
+			systemLogPth := filepath.Join(configs.DeployDir, sanitizedFileName(testRun.FullName())+".log")
+
+			fmt.Println()
+			log.Infof("Capturing simulator system log: %s", systemLogPth)
+			capture, err := startSystemLogCapture(sim, systemLogPth)
+			if err != nil {
+				log.Warnf("Failed to start capturing simulator system log, error: %s", err)
+			} else {
+				systemLogCapture = capture
+				testRun.SystemLogPth = systemLogPth
+			}
+		}
+
+		runner, err := runners.ForTestProject(job.TestProjectNames[0], job.DLLPths[0])
+		if err != nil {
+			failf("Failed to select test runner, error: %s", err)
+		}
+
+		startTime := time.Now()
+		err = runner.Run(job.DLLPths, configs.TestToRun, resultLogPth)
+
+		// the recording stops with the test run, the system log is captured until the evidence of the run is collected
+		if videoRecording != nil {
+			if err := videoRecording.Stop(); err != nil {
+				log.Warnf("Failed to stop video recording, error: %s", err)
+			}
+		}
+
+		results, parseErr := parseTestResultsFile(resultLogPth)
+		if parseErr != nil {
+			log.Warnf("Failed to parse test results, error: %s", parseErr)
+		} else {
+			testRun.Results = &results
+
+			if configs.TestResultDir != "" {
+				if err := exportToTestResultDir(configs.TestResultDir, testRun.FullName(), results); err != nil {
+					log.Warnf("Failed to export test results to the test result dir, error: %s", err)
+				}
+			}
+
+			if configs.ExportJUnitResults == "yes" {
+				junitResultPth := strings.TrimSuffix(resultLogPth, filepath.Ext(resultLogPth)) + "-JUnit.xml"
+				if err := writeJUnitResults(junitResultPth, testRun.FullName(), results); err != nil {
+					log.Warnf("Failed to write JUnit test results, error: %s", err)
+				} else {
+					log.Donef("JUnit test results: %s", junitResultPth)
+				}
+			}
+
+			if configs.ExportTRXResults == "yes" {
+				trxResultPth := strings.TrimSuffix(resultLogPth, filepath.Ext(resultLogPth)) + ".trx"
+				if err := writeTRXResults(trxResultPth, testRun.FullName(), job.DLLPths[0], results); err != nil {
+					log.Warnf("Failed to write TRX test results, error: %s", err)
+				} else {
+					log.Donef("TRX test results: %s", trxResultPth)
+				}
+			}
+		}
+
+		if parseErr == nil && err == nil && results.Total-results.Skipped == 0 {
+			message := "No test was executed, check test_to_run, test_filter, include_categories and exclude_categories"
+			if configs.NoTestsBehavior == "fail" {
+				err = errors.New(message)
+			} else {
+				log.Warnf("%s", message)
+			}
+		}
+
+		if err != nil && parseErr == nil {
+			fmt.Println()
+			logFailedTestCases(results.FailedTestCases(), stackTraceLineLimit)
+
+			if retryFailedTestsCount > 0 && len(results.FailedTestCases()) > 0 {
+				flaky, failed, retryErr := retryFailedTests(runner, job.DLLPths, resultLogPth, results, retryFailedTestsCount)
+				testRun.FlakyTests = flaky
+				if retryErr != nil {
+					log.Warnf("Failed to retry failed tests, error: %s", retryErr)
+				} else if len(failed) == 0 {
+					if configs.FailOnFlakyTests == "yes" {
+						log.Warnf("All failed tests passed on retry, but fail_on_flaky_tests is set")
+						err = fmt.Errorf("%d flaky test(s): %s", len(flaky), strings.Join(flaky, ", "))
+					} else {
+						log.Donef("All failed tests passed on retry")
+						err = nil
+					}
+				} else {
+					log.Errorf("Tests failed after %d retries:", retryFailedTestsCount)
+					for _, name := range failed {
+						log.Errorf("- %s", name)
+					}
+				}
+			}
+		}
+
+		if parseErr == nil {
+			if attachments, err := collectTestAttachments(results, configs.DeployDir, testRun.FullName()); err != nil {
+				log.Warnf("Failed to collect test attachments, error: %s", err)
+			} else if len(attachments) > 0 {
+				log.Donef("%d test attachment(s) copied into the deploy dir", len(attachments))
+				for pth, deployedPth := range attachments {
+					testRun.DeployedFiles[pth] = deployedPth
+				}
+			}
+		}
+
+		screenshotDirs := append(runnerOutputDirs(configs), job.Dirs()...)
+		if screenshots, err := collectScreenshots(screenshotDirs, startTime, configs.DeployDir, testRun.FullName()); err != nil {
+			log.Warnf("Failed to collect screenshots, error: %s", err)
+		} else if len(screenshots) > 0 {
+			log.Donef("%d screenshot(s) copied into the deploy dir", len(screenshots))
+			for pth, deployedPth := range screenshots {
+				testRun.DeployedFiles[pth] = deployedPth
+			}
+		}
+
+		if configs.NunitTrace != nunitOptionDefault && configs.NunitTrace != "Off" {
+			traceLogDirs := append(runnerOutputDirs(configs), job.Dirs()...)
+			if traceLogs, err := collectTraceLogs(traceLogDirs, startTime, configs.DeployDir, testRun.FullName()); err != nil {
+				log.Warnf("Failed to collect NUnit trace logs, error: %s", err)
+			} else if len(traceLogs) > 0 {
+				log.Donef("%d NUnit trace log(s) copied into the deploy dir", len(traceLogs))
+				for _, deployedPth := range traceLogs {
+					testRun.EvidencePths = append(testRun.EvidencePths, deployedPth)
+				}
+			}
+		}
+
+		if err != nil {
+			if crashReports, err := collectCrashReports(sim, startTime, configs.DeployDir, testRun.FullName()); err != nil {
+				log.Warnf("Failed to collect crash reports, error: %s", err)
+			} else if len(crashReports) > 0 {
+				log.Warnf("%d crash report(s) copied into the deploy dir", len(crashReports))
+				testRun.EvidencePths = append(testRun.EvidencePths, crashReports...)
+			}
+
+			if configs.CollectSimulatorDiagnostics == "yes" {
+				fmt.Println()
+				log.Infof("Collecting simulator diagnostics")
+				if diagnosticsPth, err := collectSimulatorDiagnostics(sim, configs.DeployDir, testRun.FullName()); err != nil {
+					log.Warnf("Failed to collect simulator diagnostics, error: %s", err)
+				} else {
+					log.Donef("Simulator diagnostics: %s", diagnosticsPth)
+				}
+			}
+		}
+
+		if configs.ExportAppDataContainer == "yes" {
+			if containerZipPth, err := exportAppDataContainer(sim, bundleID, configs.DeployDir, testRun.FullName()); err != nil {
+				log.Warnf("Failed to export the app's data container, error: %s", err)
+			} else {
+				log.Donef("App data container: %s", containerZipPth)
+			}
+		}
+
+		if systemLogCapture != nil {
+			if err := systemLogCapture.Stop(); err != nil {
+				log.Warnf("Failed to stop capturing simulator system log, error: %s", err)
+			}
+		}
+
+		testRun.Err = err
+		testRuns = append(testRuns, testRun)
+
+		if err != nil && configs.ContinueOnFailure != "yes" {
+			return testRuns
+		}
+	}
+
+	return testRuns
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/fileutil"
+)
+
+// TRXErrorInfoModel ...
+type TRXErrorInfoModel struct {
+	Message    string `xml:"Message"`
+	StackTrace string `xml:"StackTrace"`
+}
+
+// TRXOutputModel ...
+type TRXOutputModel struct {
+	StdOut    string             `xml:"StdOut,omitempty"`
+	ErrorInfo *TRXErrorInfoModel `xml:"ErrorInfo"`
+}
+
+// TRXUnitTestResultModel ...
+type TRXUnitTestResultModel struct {
+	ExecutionID string          `xml:"executionId,attr,omitempty"`
+	TestID      string          `xml:"testId,attr"`
+	TestName    string          `xml:"testName,attr"`
+	Duration    string          `xml:"duration,attr"`
+	Outcome     string          `xml:"outcome,attr"`
+	TestType    string          `xml:"testType,attr,omitempty"`
+	TestListID  string          `xml:"testListId,attr,omitempty"`
+	Output      *TRXOutputModel `xml:"Output"`
+}
+
+// TRXTestMethodModel ...
+type TRXTestMethodModel struct {
+	CodeBase        string `xml:"codeBase,attr,omitempty"`
+	AdapterTypeName string `xml:"adapterTypeName,attr,omitempty"`
+	ClassName       string `xml:"className,attr"`
+	Name            string `xml:"name,attr"`
+}
+
+// TRXExecutionModel ...
+type TRXExecutionModel struct {
+	ID string `xml:"id,attr"`
+}
+
+// TRXUnitTestModel ...
+type TRXUnitTestModel struct {
+	ID         string             `xml:"id,attr"`
+	Name       string             `xml:"name,attr"`
+	Storage    string             `xml:"storage,attr,omitempty"`
+	Execution  *TRXExecutionModel `xml:"Execution"`
+	TestMethod TRXTestMethodModel `xml:"TestMethod"`
+}
+
+// TRXTestEntryModel ...
+type TRXTestEntryModel struct {
+	TestID      string `xml:"testId,attr"`
+	ExecutionID string `xml:"executionId,attr"`
+	TestListID  string `xml:"testListId,attr"`
+}
+
+// TRXTestListModel ...
+type TRXTestListModel struct {
+	Name string `xml:"name,attr"`
+	ID   string `xml:"id,attr"`
+}
+
+// TRXCountersModel ...
+type TRXCountersModel struct {
+	Total        int `xml:"total,attr"`
+	Executed     int `xml:"executed,attr"`
+	Passed       int `xml:"passed,attr"`
+	Failed       int `xml:"failed,attr"`
+	Inconclusive int `xml:"inconclusive,attr"`
+	NotExecuted  int `xml:"notExecuted,attr"`
+}
+
+// TRXResultSummaryModel ...
+type TRXResultSummaryModel struct {
+	Outcome  string           `xml:"outcome,attr"`
+	Counters TRXCountersModel `xml:"Counters"`
+}
+
+// TRXTestRunModel ...
+type TRXTestRunModel struct {
+	XMLName         xml.Name                 `xml:"http://microsoft.com/schemas/VisualStudio/TeamTest/2010 TestRun"`
+	ID              string                   `xml:"id,attr"`
+	Name            string                   `xml:"name,attr"`
+	Results         []TRXUnitTestResultModel `xml:"Results>UnitTestResult"`
+	TestDefinitions []TRXUnitTestModel       `xml:"TestDefinitions>UnitTest"`
+	TestEntries     []TRXTestEntryModel      `xml:"TestEntries>TestEntry"`
+	TestLists       []TRXTestListModel       `xml:"TestLists>TestList"`
+	ResultSummary   TRXResultSummaryModel    `xml:"ResultSummary"`
+}
+
+const (
+	// trxUnitTestType is the test type id of the unit tests.
+	trxUnitTestType = "13cdc9d9-ddb5-4fa4-a97d-d965ccfc6d4b"
+	// trxResultsNotInAListID is the id of the default test list of the results.
+	trxResultsNotInAListID = "8c84fa94-04c1-424b-9868-57a2d4851a1d"
+	// trxAllLoadedResultsID is the id of the test list containing every result.
+	trxAllLoadedResultsID = "19431567-8539-422a-85d7-44ee4e166bda"
+)
+
+// trxTestResult maps the TRX test outcome to the NUnit3 test result.
+func trxTestResult(outcome string) string {
+	switch outcome {
+	case "Passed", "PassedButRunAborted":
+		return testResultPassed
+	case "Failed", "Error", "Timeout", "Aborted":
+		return testResultFailed
+	case "Inconclusive":
+		return testResultInconclusive
+	default:
+		return testResultSkipped
+	}
+}
+
+// trxOutcome maps the NUnit3 test result to the TRX test outcome.
+func trxOutcome(result string) string {
+	switch result {
+	case testResultPassed:
+		return "Passed"
+	case testResultFailed:
+		return "Failed"
+	case testResultInconclusive:
+		return "Inconclusive"
+	default:
+		return "NotExecuted"
+	}
+}
+
+// formatTRXDuration formats the duration (in seconds) in the hh:mm:ss.fffffff format of the TRX.
+func formatTRXDuration(seconds float64) string {
+	ticks := int64(seconds * 1e7)
+	return fmt.Sprintf("%02d:%02d:%02d.%07d", ticks/(3600*1e7), ticks/(60*1e7)%60, ticks/1e7%60, ticks%1e7)
+}
+
+// trxID returns a GUID derived from the given names, so the ids of a test are the same in each converted TRX.
+func trxID(names ...string) string {
+	sum := md5.Sum([]byte(strings.Join(names, "\x00")))
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// parseTRXDuration parses the hh:mm:ss.fffffff duration format of the TRX.
+func parseTRXDuration(duration string) float64 {
+	var hours, minutes int
+	var seconds float64
+	if _, err := fmt.Sscanf(duration, "%d:%d:%g", &hours, &minutes, &seconds); err != nil {
+		return 0
+	}
+	return float64(hours*3600+minutes*60) + seconds
+}
+
+func timeAttr(element xml.StartElement, name string) (time.Time, bool) {
+	for _, attr := range element.Attr {
+		if attr.Name.Local == name {
+			t, err := time.Parse(time.RFC3339Nano, attr.Value)
+			return t, err == nil
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseTRXResults parses the rest of a TRX (Visual Studio test results) document,
+// the decoder has to be positioned after the TestRun start element.
+// The fixture of the test cases is only known from the test definitions, which may follow the results,
+// so the test cases are completed after the whole document is read.
+func parseTRXResults(decoder *xml.Decoder) (TestResultsModel, error) {
+	results := TestResultsModel{}
+	durationSet := false
+	classNameByTestID := map[string]string{}
+	testIDs := []string{}
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return TestResultsModel{}, fmt.Errorf("Failed to parse test results, error: %s", err)
+		}
+
+		element, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch element.Name.Local {
+		case "Times":
+			start, okStart := timeAttr(element, "start")
+			finish, okFinish := timeAttr(element, "finish")
+			if okStart && okFinish {
+				results.Duration = finish.Sub(start).Seconds()
+				durationSet = true
+			}
+		case "UnitTestResult":
+			var unitTestResult TRXUnitTestResultModel
+			if err := decoder.DecodeElement(&unitTestResult, &element); err != nil {
+				return TestResultsModel{}, fmt.Errorf("Failed to parse test result, error: %s", err)
+			}
+
+			testCase := TestCaseModel{
+				ID:       unitTestResult.TestID,
+				Name:     unitTestResult.TestName,
+				Result:   trxTestResult(unitTestResult.Outcome),
+				Duration: parseTRXDuration(unitTestResult.Duration),
+			}
+			if unitTestResult.Output != nil && unitTestResult.Output.ErrorInfo != nil {
+				errorInfo := unitTestResult.Output.ErrorInfo
+				info := &FailureModel{Message: errorInfo.Message, StackTrace: errorInfo.StackTrace}
+				if testCase.Result == testResultFailed {
+					testCase.Failure = info
+				} else {
+					testCase.Reason = info
+				}
+			}
+
+			results.TestCases = append(results.TestCases, testCase)
+			testIDs = append(testIDs, testCase.ID)
+		case "UnitTest":
+			var unitTest TRXUnitTestModel
+			if err := decoder.DecodeElement(&unitTest, &element); err != nil {
+				return TestResultsModel{}, fmt.Errorf("Failed to parse test definition, error: %s", err)
+			}
+			classNameByTestID[unitTest.ID] = unitTest.TestMethod.ClassName
+		case "Counters":
+			results.Total = intAttr(element, "total")
+			results.Passed = intAttr(element, "passed")
+			results.Failed = intAttr(element, "failed") + intAttr(element, "error") + intAttr(element, "timeout") + intAttr(element, "aborted")
+			results.Inconclusive = intAttr(element, "inconclusive")
+			results.Skipped = intAttr(element, "notExecuted") + intAttr(element, "notRunnable")
+		}
+	}
+
+	for i, testID := range testIDs {
+		testCase := &results.TestCases[i]
+		testCase.ClassName = classNameByTestID[testID]
+		// the NUnit adapter writes the method name as the test name, xUnit.net writes the full name
+		testCase.FullName = testCase.Name
+		if testCase.ClassName != "" && !strings.HasPrefix(testCase.Name, testCase.ClassName+".") {
+			testCase.FullName = testCase.ClassName + "." + testCase.Name
+		}
+		if !durationSet {
+			results.Duration += testCase.Duration
+		}
+	}
+
+	return results, nil
+}
+
+// convertToTRX converts the NUnit test results to a TRX (Visual Studio test results) document.
+func convertToTRX(name, assemblyPth string, results TestResultsModel) TRXTestRunModel {
+	testRun := TRXTestRunModel{
+		ID:   trxID(name),
+		Name: name,
+		TestLists: []TRXTestListModel{
+			{Name: "Results Not in a List", ID: trxResultsNotInAListID},
+			{Name: "All Loaded Results", ID: trxAllLoadedResultsID},
+		},
+	}
+	counters := TRXCountersModel{}
+
+	for _, testCase := range results.TestCases {
+		testID := trxID(name, testCase.FullName)
+		executionID := trxID(name, testCase.FullName, testCase.ID)
+		outcome := trxOutcome(testCase.Result)
+
+		unitTestResult := TRXUnitTestResultModel{
+			ExecutionID: executionID,
+			TestID:      testID,
+			TestName:    testCase.Name,
+			Duration:    formatTRXDuration(testCase.Duration),
+			Outcome:     outcome,
+			TestType:    trxUnitTestType,
+			TestListID:  trxResultsNotInAListID,
+		}
+		info := testCase.Failure
+		if info == nil {
+			info = testCase.Reason
+		}
+		if info != nil {
+			unitTestResult.Output = &TRXOutputModel{ErrorInfo: &TRXErrorInfoModel{
+				Message:    maskSecrets(strings.TrimSpace(info.Message)),
+				StackTrace: maskSecrets(strings.TrimSpace(info.StackTrace)),
+			}}
+		}
+
+		testRun.Results = append(testRun.Results, unitTestResult)
+		testRun.TestDefinitions = append(testRun.TestDefinitions, TRXUnitTestModel{
+			ID:        testID,
+			Name:      testCase.Name,
+			Storage:   assemblyPth,
+			Execution: &TRXExecutionModel{ID: executionID},
+			TestMethod: TRXTestMethodModel{
+				CodeBase:        assemblyPth,
+				AdapterTypeName: "executor://nunit3testexecutor/",
+				ClassName:       testCase.Fixture(),
+				Name:            testCase.Name,
+			},
+		})
+		testRun.TestEntries = append(testRun.TestEntries, TRXTestEntryModel{
+			TestID:      testID,
+			ExecutionID: executionID,
+			TestListID:  trxResultsNotInAListID,
+		})
+
+		counters.Total++
+		switch outcome {
+		case "Passed":
+			counters.Executed++
+			counters.Passed++
+		case "Failed":
+			counters.Executed++
+			counters.Failed++
+		case "Inconclusive":
+			counters.Executed++
+			counters.Inconclusive++
+		default:
+			counters.NotExecuted++
+		}
+	}
+
+	testRun.ResultSummary = TRXResultSummaryModel{Outcome: "Completed", Counters: counters}
+	if counters.Failed > 0 {
+		testRun.ResultSummary.Outcome = "Failed"
+	}
+
+	return testRun
+}
+
+func writeTRXResults(pth, name, assemblyPth string, results TestResultsModel) error {
+	content, err := xml.MarshalIndent(convertToTRX(name, assemblyPth, results), "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to serialize TRX results, error: %s", err)
+	}
+
+	return fileutil.WriteStringToFile(pth, xml.Header+string(content))
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/command"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/pathutil"
+)
+
+// xunitConsoleRunnerPattern matches the .NET Framework xunit.console.exe of the xunit.runner.console NuGet package.
+var xunitConsoleRunnerPattern = regexp.MustCompile(`(?i)xunit\.runner\.console[./](\d+(\.\d+)*)[^/]*/tools/(net4\d*/)?xunit\.console\.exe$`)
+
+// typedefPattern matches the type definitions listed by `monodis --typedef`, like `2: Tests.LoginTests (flist=1, ...)`.
+var typedefPattern = regexp.MustCompile(`(?m)^\d+:\s+(\S+)\s+\(`)
+
+// assemblyTypeNames returns the full names of the types defined in the .NET assemblies, read by monodis,
+// nested types are named as in xUnit.net (`Outer+Inner`).
+func assemblyTypeNames(monoPth string, assemblyPths []string) (map[string]bool, error) {
+	monodis := filepath.Join(filepath.Dir(monoPth), "monodis")
+
+	names := map[string]bool{}
+	for _, assemblyPth := range assemblyPths {
+		out, err := command.New(monodis, "--typedef", assemblyPth).RunAndReturnTrimmedCombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read type definitions of (%s), output: %s, error: %s", assemblyPth, out, err)
+		}
+
+		for _, match := range typedefPattern.FindAllStringSubmatch(out, -1) {
+			names[strings.Replace(match[1], "/", "+", -1)] = true
+		}
+	}
+	return names, nil
+}
+
+// isXunitAssembly returns true if the test assembly uses xUnit.net instead of NUnit,
+// based on the xUnit.net assemblies copied next to it.
+func isXunitAssembly(dllPth string) (bool, error) {
+	for _, name := range []string{"xunit.core.dll", "xunit.execution.desktop.dll", "xunit.execution.dotnet.dll"} {
+		pth := filepath.Join(filepath.Dir(dllPth), name)
+		if exist, err := pathutil.IsPathExists(pth); err != nil {
+			return false, fmt.Errorf("Failed to check if file (%s) exist, error: %s", pth, err)
+		} else if exist {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// resolveXunitConsolePath returns the xunit.console.exe to use: the configured one,
+// or the one installed by the xunit.runner.console NuGet package.
+func resolveXunitConsolePath(configuredPth, solutionPth string) (string, error) {
+	if configuredPth != "" {
+		return configuredPth, nil
+	}
+
+	dirs := []string{
+		filepath.Join(filepath.Dir(solutionPth), "packages"),
+		filepath.Join(pathutil.UserHomeDir(), ".nuget", "packages", "xunit.runner.console"),
+	}
+	pth, err := findNugetConsoleRunner(dirs, xunitConsoleRunnerPattern)
+	if err != nil {
+		return "", err
+	}
+	if pth == "" {
+		return "", fmt.Errorf("set xunit_console_path or add the xunit.runner.console NuGet package to the solution")
+	}
+	return pth, nil
+}
+
+// xunitRunner runs the tests of xUnit.net test assemblies with xunit.console,
+// which writes the results in NUnit 2.x format.
+type xunitRunner struct {
+	monoPth    string
+	consolePth string
+	options    []string
+	envs       []string
+}
+
+// commandSlice returns the xunit.console command, the test names found in classNames select test classes,
+// the others select test methods.
+// xunit.console ANDs the different filter types, so every name gets only one of them.
+func (runner xunitRunner) commandSlice(dllPths []string, testToRun, resultLogPth string, classNames map[string]bool) []string {
+	cmdSlice := append(append([]string{runner.monoPth, runner.consolePth}, dllPths...), "-nologo")
+	if resultLogPth != "" {
+		cmdSlice = append(cmdSlice, "-nunit", resultLogPth)
+	}
+	for _, name := range splitTestNames(testToRun) {
+		if classNames[name] {
+			cmdSlice = append(cmdSlice, "-class", name)
+		} else {
+			cmdSlice = append(cmdSlice, "-method", name)
+		}
+	}
+	return append(cmdSlice, runner.options...)
+}
+
+// Run ...
+func (runner xunitRunner) Run(dllPths []string, testToRun, resultLogPth string) error {
+	classNames := map[string]bool{}
+	if len(splitTestNames(testToRun)) > 0 {
+		names, err := assemblyTypeNames(runner.monoPth, dllPths)
+		if err != nil {
+			log.Warnf("%s, the test names are used as test method names", err)
+		} else {
+			classNames = names
+		}
+	}
+
+	return runTestCommand("Running Xamarin UITest (xUnit.net)", runner.commandSlice(dllPths, testToRun, resultLogPth, classNames), runner.envs)
+}
+
+// xunitOptions returns the xunit.console options configured by the step inputs,
+// and warnings about the inputs xunit.console does not support.
+// Categories are mapped to the Category trait.
+func xunitOptions(configs ConfigsModel) ([]string, []string) {
+	options := []string{}
+	warnings := []string{}
+
+	for _, category := range splitList(configs.IncludeCategories) {
+		options = append(options, "-trait", "Category="+category)
+	}
+	for _, category := range excludedCategories(configs) {
+		options = append(options, "-notrait", "Category="+category)
+	}
+	if configs.NunitWorkers != "" {
+		options = append(options, "-maxthreads", configs.NunitWorkers)
+	}
+	if configs.StopOnFirstFailure == "yes" {
+		options = append(options, "-stoponfail")
+	}
+
+	if configs.TestFilter != "" {
+		warnings = append(warnings, "test_filter is not supported by xunit.console, use include_categories, exclude_categories or test_to_run instead")
+	}
+	if configs.TestListPath != "" {
+		warnings = append(warnings, "test_list_path is not supported by xunit.console")
+	}
+	if timeout, _ := parseNonNegativeInt(configs.TestTimeoutSeconds); timeout > 0 {
+		warnings = append(warnings, "test_timeout_seconds is not supported by xunit.console")
+	}
+	if len(testParams(configs.TestParams)) > 0 {
+		warnings = append(warnings, "test_params is not supported by xunit.console")
+	}
+	if configs.TestSeed != "" {
+		warnings = append(warnings, "test_seed is only used to order the test projects, xunit.console does not support random seed")
+	}
+
+	return options, warnings
+}